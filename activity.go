@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bytes per sector in /proc/diskstats, regardless of the actual sector size.
+const diskstatsSectorSize = 512
+
+// activitySample holds cumulative counters, activity is computed from the
+// difference between two samples.
+type activitySample struct {
+	cpuBusy   uint64
+	cpuTotal  uint64
+	diskBytes uint64
+	hasDisk   bool
+}
+
+// An activitySource samples the CPU and disk counters of the computer.
+type activitySource interface {
+	sample() (activitySample, error)
+}
+
+// procActivitySource reads the counters from the stat and diskstats files of
+// root, /proc by default. --proc-root points it to copies of the files, eg.
+// exported from another machine when the server runs on Windows.
+type procActivitySource struct {
+	root string
+}
+
+func (p procActivitySource) sample() (activitySample, error) {
+	var s activitySample
+	var err error
+
+	if s.cpuBusy, s.cpuTotal, err = readProcStat(filepath.Join(p.root, "stat")); err != nil {
+		return s, err
+	}
+	s.diskBytes, err = readDiskstats(filepath.Join(p.root, "diskstats"))
+	if err == nil {
+		s.hasDisk = true
+	} else if !os.IsNotExist(err) {
+		return s, err
+	}
+	return s, nil
+}
+
+// readProcStat returns the busy and total CPU ticks from the "cpu" line of /proc/stat.
+func readProcStat(path string) (busy, total uint64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close() //nolint:errcheck
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || fields[0] != "cpu" {
+			continue
+		}
+		// user nice system idle iowait irq softirq steal (guest is included in user)
+		for i, field := range fields[1:min(len(fields), 9)] {
+			ticks, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return 0, 0, fmt.Errorf("%s: %v", path, err)
+			}
+			total += ticks
+			if i != 3 && i != 4 { // idle and iowait
+				busy += ticks
+			}
+		}
+		return busy, total, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, err
+	}
+	return 0, 0, fmt.Errorf("%s: no cpu line", path)
+}
+
+// readDiskstats returns the bytes read and written by all disks. Partitions
+// are skipped because their I/O is already counted by the disk they belong to.
+func readDiskstats(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	type device struct {
+		name    string
+		sectors uint64
+	}
+	var devices []device
+	for _, line := range strings.Split(string(data), "\n") {
+		// major minor name reads merged sectors_read ms writes merged sectors_written ...
+		fields := strings.Fields(line)
+		if len(fields) < 10 {
+			continue
+		}
+		name := fields[2]
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") {
+			continue
+		}
+		read, err1 := strconv.ParseUint(fields[5], 10, 64)
+		written, err2 := strconv.ParseUint(fields[9], 10, 64)
+		if err1 != nil || err2 != nil {
+			return 0, fmt.Errorf("%s: invalid line %q", path, line)
+		}
+		devices = append(devices, device{name, read + written})
+	}
+
+	var sectors uint64
+	for _, d := range devices {
+		partition := false
+		for _, other := range devices {
+			if isPartitionOf(d.name, other.name) {
+				partition = true
+				break
+			}
+		}
+		if !partition {
+			sectors += d.sectors
+		}
+	}
+	return sectors * diskstatsSectorSize, nil
+}
+
+// isPartitionOf reports whether name is a partition of disk, eg. sda1 of sda
+// or nvme0n1p2 of nvme0n1.
+func isPartitionOf(name, disk string) bool {
+	suffix, ok := strings.CutPrefix(name, disk)
+	if !ok || suffix == "" {
+		return false
+	}
+	suffix = strings.TrimPrefix(suffix, "p")
+	_, err := strconv.Atoi(suffix)
+	return err == nil
+}
+
+// activityCondition is met while CPU utilisation or disk throughput exceed
+// their thresholds. A threshold of 0 disables the check.
+type activityCondition struct {
+	source        activitySource
+	cpuThreshold  float64 // percent
+	diskThreshold float64 // bytes per second
+
+	previous     activitySample
+	previousTime time.Time
+}
+
+func (a *activityCondition) check(now time.Time) (conditionResult, error) {
+	s, err := a.source.sample()
+	if err != nil {
+		return conditionResult{}, err
+	}
+	previous, previousTime := a.previous, a.previousTime
+	a.previous, a.previousTime = s, now
+	if previousTime.IsZero() {
+		return conditionResult{reason: "waiting for a second sample"}, nil
+	}
+
+	result := conditionResult{readings: make(map[string]float64)}
+	var reasons []string
+
+	if a.cpuThreshold > 0 && s.cpuTotal > previous.cpuTotal && s.cpuBusy >= previous.cpuBusy {
+		cpu := 100 * float64(s.cpuBusy-previous.cpuBusy) / float64(s.cpuTotal-previous.cpuTotal)
+		result.readings["cpu_percent"] = cpu
+		if cpu >= a.cpuThreshold {
+			result.met = true
+			reasons = append(reasons, fmt.Sprintf("cpu %.1f%% >= %.1f%%", cpu, a.cpuThreshold))
+		}
+	}
+	if elapsed := now.Sub(previousTime).Seconds(); a.diskThreshold > 0 && s.hasDisk && previous.hasDisk &&
+		elapsed > 0 && s.diskBytes >= previous.diskBytes {
+		disk := float64(s.diskBytes-previous.diskBytes) / elapsed
+		result.readings["disk_bytes_per_sec"] = disk
+		if disk >= a.diskThreshold {
+			result.met = true
+			reasons = append(reasons, fmt.Sprintf("disk %.1f MB/s >= %.1f MB/s", disk/1e6, a.diskThreshold/1e6))
+		}
+	}
+
+	if result.met {
+		result.reason = strings.Join(reasons, ", ")
+	} else {
+		result.reason = "activity below thresholds"
+	}
+	return result, nil
+}
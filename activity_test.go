@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const diskstatsFixture = `   8       0 sda 100 0 %d 0 100 0 %d 0 0 0 0 0 0 0 0 0 0
+   8       1 sda1 100 0 %d 0 100 0 %d 0 0 0 0 0 0 0 0 0 0
+ 259       0 nvme0n1 10 0 1000 0 10 0 1000 0 0 0 0 0 0 0 0 0 0
+ 259       1 nvme0n1p1 10 0 1000 0 10 0 1000 0 0 0 0 0 0 0 0 0 0
+   7       0 loop0 10 0 5000 0 0 0 0 0 0 0 0 0 0 0 0 0 0
+`
+
+// writeProcFixture writes stat and diskstats files with the given counters.
+func writeProcFixture(t *testing.T, root string, busy, idle, sectors uint64) {
+	t.Helper()
+	half := sectors / 2
+	writeFixture(t, root, map[string]string{
+		"stat":      fmt.Sprintf("cpu  %d 0 0 %d 0 0 0 0 0 0\ncpu0 1 2 3 4 5 6 7 8 0 0\n", busy, idle),
+		"diskstats": fmt.Sprintf(diskstatsFixture, half, half, half, half),
+	})
+}
+
+func TestProcActivitySource(t *testing.T) {
+	root := t.TempDir()
+	writeProcFixture(t, root, 300, 700, 4000)
+
+	s, err := procActivitySource{root: root}.sample()
+	if err != nil {
+		t.Fatalf("sample: %v", err)
+	}
+	if s.cpuBusy != 300 || s.cpuTotal != 1000 {
+		t.Errorf("expected cpu 300/1000, got %d/%d", s.cpuBusy, s.cpuTotal)
+	}
+	// sda (4000 sectors) and nvme0n1 (2000 sectors), partitions and loop devices are skipped
+	if want := uint64(6000 * diskstatsSectorSize); !s.hasDisk || s.diskBytes != want {
+		t.Errorf("expected %d disk bytes, got %d (hasDisk: %v)", want, s.diskBytes, s.hasDisk)
+	}
+
+	// diskstats is optional
+	os.Remove(filepath.Join(root, "diskstats"))
+	if s, err = (procActivitySource{root: root}).sample(); err != nil || s.hasDisk {
+		t.Errorf("expected a cpu only sample, got %+v, %v", s, err)
+	}
+}
+
+func TestActivityCondition(t *testing.T) {
+	root := t.TempDir()
+	activity := &activityCondition{
+		source:        procActivitySource{root: root},
+		cpuThreshold:  50,
+		diskThreshold: 1e6,
+	}
+	start := time.Now()
+
+	writeProcFixture(t, root, 0, 0, 0)
+	if result, err := activity.check(start); err != nil || result.met {
+		t.Fatalf("first sample should not be met: %+v, %v", result, err)
+	}
+
+	// 20% cpu, 4000 sectors in 10s = 0.2 MB/s
+	writeProcFixture(t, root, 20, 80, 4000)
+	result, err := activity.check(start.Add(10 * time.Second))
+	if err != nil || result.met {
+		t.Fatalf("expected activity below thresholds: %+v, %v", result, err)
+	}
+	if result.readings["cpu_percent"] != 20 {
+		t.Errorf("expected 20%% cpu, got %v", result.readings["cpu_percent"])
+	}
+
+	// 90% cpu
+	writeProcFixture(t, root, 110, 90, 4000)
+	if result, _ = activity.check(start.Add(20 * time.Second)); !result.met {
+		t.Errorf("expected cpu activity to be met: %+v", result)
+	}
+
+	// 10% cpu, 20000 sectors in 10s = 1 MB/s
+	writeProcFixture(t, root, 120, 180, 24000)
+	if result, _ = activity.check(start.Add(30 * time.Second)); !result.met {
+		t.Errorf("expected disk activity to be met: %+v", result)
+	}
+}
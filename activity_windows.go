@@ -0,0 +1,23 @@
+//go:build windows
+
+package main
+
+// windowsActivitySource samples the CPU counters with GetSystemTimes. Disk
+// counters are not available, use a procActivitySource to provide them.
+type windowsActivitySource struct{}
+
+func (windowsActivitySource) sample() (activitySample, error) {
+	idle, kernel, user, err := GetSystemTimes()
+	if err != nil {
+		return activitySample{}, err
+	}
+	// kernel time includes idle time
+	return activitySample{
+		cpuBusy:  kernel + user - idle,
+		cpuTotal: kernel + user,
+	}, nil
+}
+
+func defaultActivitySource() activitySource {
+	return windowsActivitySource{}
+}
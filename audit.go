@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// AuditRecord is a line of the audit log, written for every call that changes
+// the state. Identity is authenticated by the transport (unix socket peer
+// credentials on Linux), Client is the name declared by the caller.
+type AuditRecord struct {
+	Time     time.Time `json:"time"`
+	Method   string    `json:"method"`
+	Flags    uint32    `json:"flags"`
+	Mode     string    `json:"mode"`
+	Remote   string    `json:"remote,omitempty"`
+	Identity string    `json:"identity,omitempty"`
+	Client   string    `json:"client,omitempty"`
+	Process  int       `json:"pid,omitempty"`
+	Detail   string    `json:"detail,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// matches reports whether the record is in [since, until) and was made by
+// identity (authenticated or declared). Zero values match everything.
+func (r AuditRecord) matches(since, until time.Time, identity string) bool {
+	return (since.IsZero() || !r.Time.Before(since)) &&
+		(until.IsZero() || r.Time.Before(until)) &&
+		(identity == "" || r.Identity == identity || r.Client == identity)
+}
+
+// auditLog appends records to a JSON Lines file, separate from the diagnostic log.
+type auditLog struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+func openAuditLog(path string) (*auditLog, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{path: path, file: file}, nil
+}
+
+func (a *auditLog) record(r AuditRecord) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		log.Printf("Audit log encoding error: %v", err)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, err := a.file.Write(append(data, '\n')); err != nil {
+		log.Printf("Audit log write error: %v", err)
+	}
+}
+
+func (a *auditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.file.Close()
+}
+
+// queryAuditLog returns the records of the audit log file matching the filter.
+func queryAuditLog(path string, since, until time.Time, identity string) ([]AuditRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() //nolint:errcheck
+
+	var records []AuditRecord
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var r AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		if r.matches(since, until, identity) {
+			records = append(records, r)
+		}
+	}
+	return records, scanner.Err()
+}
+
+// auditCall records a call that changes the state, if the audit log is enabled.
+func (m *ExecStateManager) auditCall(method string, req ExecStateRequest, detail string, err error) {
+	if m.audit == nil {
+		return
+	}
+	flags := m.appliedFlags()
+	r := AuditRecord{
+		Time:     m.now(),
+		Method:   method,
+		Flags:    flags,
+		Mode:     modeName(flags),
+		Remote:   req.remote,
+		Identity: req.identity,
+		Client:   req.Client,
+		Process:  req.Process,
+		Detail:   detail,
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	m.audit.record(r)
+}
+
+// parseTimeArg parses an absolute time (RFC 3339 or YYYY-MM-DD, local time)
+// or a duration before now, like "24h".
+func parseTimeArg(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (expected RFC 3339, YYYY-MM-DD or a duration)", s)
+}
+
+// auditCommand implements the "audit" command, which queries an audit log file.
+func auditCommand(args []string) int {
+	fs := flag.NewFlagSet("audit", flag.ContinueOnError)
+	since := fs.String("since", "", "only records at or after this time (RFC 3339, YYYY-MM-DD or duration ago)")
+	until := fs.String("until", "", "only records before this time")
+	identity := fs.String("identity", "", "only records of this identity or client name")
+	asJSON := fs.Bool("json", false, "print records as JSON Lines")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: "+name+" audit [OPTIONS] FILE\n\nQueries the audit log written with --audit.\n\nOPTIONS:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	now := time.Now()
+	from, err := parseTimeArg(*since, now)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	to, err := parseTimeArg(*until, now)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	records, err := queryAuditLog(fs.Arg(0), from, to, *identity)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, r := range records {
+			enc.Encode(r) //nolint:errcheck
+		}
+		return 0
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tMETHOD\tMODE\tIDENTITY\tCLIENT\tREMOTE\tPID\tDETAIL")
+	for _, r := range records {
+		detail := r.Detail
+		if r.Error != "" {
+			detail = strings.TrimSpace(detail + " error: " + r.Error)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n", r.Time.Local().Format(time.DateTime),
+			r.Method, r.Mode, r.Identity, r.Client, r.Remote, r.Process, detail)
+	}
+	w.Flush() //nolint:errcheck
+	return 0
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestParseTimeArg(t *testing.T) {
+	now := time.Date(2026, 3, 4, 21, 0, 0, 0, time.UTC)
+	tests := []struct {
+		arg  string
+		want time.Time
+	}{
+		{"", time.Time{}},
+		{"24h", now.Add(-24 * time.Hour)},
+		{"2026-03-04T20:00:00Z", time.Date(2026, 3, 4, 20, 0, 0, 0, time.UTC)},
+		{"2026-03-04", time.Date(2026, 3, 4, 0, 0, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		got, err := parseTimeArg(tt.arg, now)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseTimeArg(%q) = %v, %v, want %v", tt.arg, got, err, tt.want)
+		}
+	}
+	if _, err := parseTimeArg("yesterday", now); err == nil {
+		t.Error("expected an error for an invalid time")
+	}
+}
+
+func TestRPCAudit(t *testing.T) {
+	manager, listener, client := setupTestServer(t)
+	defer listener.Close()
+	defer client.Close()
+	defer manager.Stop()
+
+	start := time.Now()
+	audit, err := openAuditLog(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatalf("openAuditLog failed: %v", err)
+	}
+	defer audit.Close()
+	manager.audit = audit
+
+	calls := []struct {
+		method string
+		req    ExecStateRequest
+	}{
+		{"Display", ExecStateRequest{Client: "backup"}},
+		{"Register", ExecStateRequest{Process: 42, Client: "backup"}},
+		{"Read", ExecStateRequest{Client: "tray"}}, // not recorded
+		{"Clear", ExecStateRequest{Client: "tray"}},
+	}
+	for _, c := range calls {
+		if err := client.Call("ExecStateManager."+c.method, c.req, &ExecStateReply{}); err != nil {
+			t.Fatalf("%s RPC call failed: %v", c.method, err)
+		}
+	}
+	if err := client.Call("ExecStateManager.RemoveSchedule", ExecStateRequest{RuleID: 7, Client: "tray"}, &ExecStateReply{}); err == nil {
+		t.Fatal("expected RemoveSchedule of an unknown rule to fail")
+	}
+
+	var reply ExecStateReply
+	if err := client.Call("ExecStateManager.QueryAudit", ExecStateRequest{Since: start}, &reply); err != nil {
+		t.Fatalf("QueryAudit RPC call failed: %v", err)
+	}
+	want := []struct{ method, mode string }{{"Display", "display"}, {"Register", "display"}, {"Clear", "clear"}, {"RemoveSchedule", "clear"}}
+	if len(reply.Audit) != len(want) {
+		t.Fatalf("expected %d records, got %+v", len(want), reply.Audit)
+	}
+	for i, w := range want {
+		if r := reply.Audit[i]; r.Method != w.method || r.Mode != w.mode || r.Remote == "" {
+			t.Errorf("record %d: expected %s in %s mode with a remote address, got %+v", i, w.method, w.mode, r)
+		}
+	}
+	if r := reply.Audit[1]; r.Process != 42 || r.Client != "backup" {
+		t.Errorf("expected Register of process 42 by backup, got %+v", r)
+	}
+	if r := reply.Audit[3]; r.Error == "" {
+		t.Errorf("expected the failed RemoveSchedule to record its error, got %+v", r)
+	}
+
+	reply = ExecStateReply{}
+	if err := client.Call("ExecStateManager.QueryAudit", ExecStateRequest{Identity: "tray"}, &reply); err != nil {
+		t.Fatalf("QueryAudit RPC call failed: %v", err)
+	}
+	if len(reply.Audit) != 2 {
+		t.Errorf("expected 2 records by tray, got %+v", reply.Audit)
+	}
+
+	reply = ExecStateReply{}
+	if err := client.Call("ExecStateManager.QueryAudit", ExecStateRequest{Until: start}, &reply); err != nil {
+		t.Fatalf("QueryAudit RPC call failed: %v", err)
+	}
+	if len(reply.Audit) != 0 {
+		t.Errorf("expected no records before the start, got %+v", reply.Audit)
+	}
+}
+
+func TestPeerIdentity(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are only available on Linux")
+	}
+	listener, err := net.Listen("unix", filepath.Join(t.TempDir(), "rpc.sock"))
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+	client, err := net.Dial("unix", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer client.Close()
+
+	conn := <-accepted
+	if conn == nil {
+		t.Fatal("Accept failed")
+	}
+	defer conn.Close()
+	if identity := peerIdentity(conn); identity == "" {
+		t.Error("expected the identity of the peer of a unix socket")
+	}
+	if identity := peerIdentity(&net.TCPConn{}); identity != "" {
+		t.Errorf("expected no identity for a TCP connection, got %q", identity)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// Version of the RPC protocol, incremented when a change breaks clients (a
+// method or field removed or changing meaning). Adding methods and fields does
+// not, gob ignores the fields it does not know: clients find out about them
+// with Hello.
+const (
+	protocolVersion    = 1
+	minProtocolVersion = 1 // oldest client protocol still served
+)
+
+// Features always provided by this version of the server.
+var baseFeatures = []string{"events", "handles", "leases", "conn-holds", "schedules", "report"}
+
+// Capabilities describes the server to clients, returned by Hello.
+type Capabilities struct {
+	Name        string
+	Version     string
+	Commit      string
+	Protocol    int // protocol version of the server
+	MinProtocol int // oldest client protocol version served
+	Methods     []string
+	Backend     string   // how the execution state is applied
+	Codecs      []string // RPC codecs accepted
+	Features    []string // base features, and the optional ones enabled
+}
+
+// Check returns an error if a client speaking protocol cannot talk to the
+// server, or if the server lacks one of the methods.
+func (c *Capabilities) Check(protocol int, methods ...string) error {
+	if protocol < c.MinProtocol || protocol > c.Protocol {
+		return fmt.Errorf("protocol version %d not supported by %s %s (supports %d to %d)", protocol, c.Name, c.Version, c.MinProtocol, c.Protocol)
+	}
+	for _, method := range methods {
+		if !slices.Contains(c.Methods, method) {
+			return fmt.Errorf("method %s not supported by %s %s", method, c.Name, c.Version)
+		}
+	}
+	return nil
+}
+
+// HasFeature reports whether the server provides a feature.
+func (c *Capabilities) HasFeature(feature string) bool {
+	return slices.Contains(c.Features, feature)
+}
+
+// rpcMethods returns the names of the methods of the manager served by net/rpc.
+func rpcMethods() []string {
+	var methods []string
+	t := reflect.TypeFor[*ExecStateManager]()
+	for i := range t.NumMethod() {
+		mt := t.Method(i).Type
+		if mt.NumIn() == 3 && mt.In(1) == reflect.TypeFor[ExecStateRequest]() &&
+			mt.In(2) == reflect.TypeFor[*ExecStateReply]() &&
+			mt.NumOut() == 1 && mt.Out(0) == reflect.TypeFor[error]() {
+			methods = append(methods, t.Method(i).Name)
+		}
+	}
+	return methods
+}
+
+// backendName returns how the execution state is applied on this platform.
+func backendName() string {
+	switch runtime.GOOS {
+	case "windows":
+		return "SetThreadExecutionState"
+	case "linux":
+		return "systemd-inhibit"
+	default:
+		return "none"
+	}
+}
+
+// capabilities returns the capabilities of the server as configured.
+func (m *ExecStateManager) capabilities() *Capabilities {
+	features := slices.Clone(baseFeatures)
+	if m.audit != nil {
+		features = append(features, "audit")
+	}
+	if m.power != nil {
+		features = append(features, "power")
+	}
+	if len(m.conditions) > 0 {
+		features = append(features, "conditions")
+	}
+	if m.reassertInterval > 0 {
+		features = append(features, "reassert")
+	}
+	if len(m.upstreams) > 0 {
+		features = append(features, "relay")
+	}
+	return &Capabilities{
+		Name:        name,
+		Version:     version,
+		Commit:      commit,
+		Protocol:    protocolVersion,
+		MinProtocol: minProtocolVersion,
+		Methods:     rpcMethods(),
+		Backend:     backendName(),
+		Codecs:      []string{"gob"},
+		Features:    features,
+	}
+}
+
+// helloCommand prints the capabilities of the server, and fails if this
+// client cannot talk to it.
+func helloCommand(cfg *Config, args []string) int {
+	fs := flag.NewFlagSet("hello", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the capabilities as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: "+name+" [-n network] [-a address] [-p port] hello [OPTIONS]\n\nPrints the version and capabilities of the server, and checks that this client is compatible.\n\nOPTIONS:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+
+	client, err := dialServer(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer client.Close() //nolint:errcheck
+
+	var reply ExecStateReply
+	if err := client.Call("ExecStateManager.Hello", ExecStateRequest{Protocol: protocolVersion}, &reply); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	c := reply.Capabilities
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(c) //nolint:errcheck
+	} else {
+		fmt.Printf("Server:   %s %s (commit: %s)\n", c.Name, c.Version, c.Commit)
+		fmt.Printf("Protocol: %d (clients %d to %d)\n", c.Protocol, c.MinProtocol, c.Protocol)
+		fmt.Printf("Backend:  %s\n", c.Backend)
+		fmt.Printf("Codecs:   %s\n", strings.Join(c.Codecs, ", "))
+		fmt.Printf("Methods:  %s\n", strings.Join(c.Methods, ", "))
+		fmt.Printf("Features: %s\n", strings.Join(c.Features, ", "))
+	}
+	if err := c.Check(protocolVersion); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
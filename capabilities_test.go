@@ -0,0 +1,40 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCapabilities(t *testing.T) {
+	manager, listener, client := setupTestServer(t)
+	defer listener.Close()
+	defer client.Close()
+	defer manager.Stop()
+
+	var reply ExecStateReply
+	if err := client.Call("ExecStateManager.Hello", ExecStateRequest{Protocol: protocolVersion}, &reply); err != nil {
+		t.Fatalf("Hello RPC call failed: %v", err)
+	}
+	c := reply.Capabilities
+	if c == nil || c.Protocol != protocolVersion || c.MinProtocol != minProtocolVersion {
+		t.Fatalf("expected the protocol versions, got %+v", c)
+	}
+	for _, method := range []string{"Hello", "Acquire", "Hold", "Shutdown"} {
+		if !slices.Contains(c.Methods, method) {
+			t.Errorf("expected method %s in %v", method, c.Methods)
+		}
+	}
+	if !c.HasFeature("leases") || c.HasFeature("audit") {
+		t.Errorf("expected the base features only, got %v", c.Features)
+	}
+
+	if err := c.Check(protocolVersion, "Acquire", "Heartbeat"); err != nil {
+		t.Errorf("expected the client to be compatible: %v", err)
+	}
+	if err := c.Check(protocolVersion + 1); err == nil {
+		t.Error("expected an error for a newer protocol")
+	}
+	if err := c.Check(protocolVersion, "Teleport"); err == nil {
+		t.Error("expected an error for an unknown method")
+	}
+}
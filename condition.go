@@ -0,0 +1,131 @@
+package main
+
+import (
+	"log"
+	"maps"
+	"sync"
+	"time"
+)
+
+// How often conditions are checked.
+const conditionInterval = 10 * time.Second
+
+// A condition detects something the computer should stay awake for, like a
+// running job that does not register itself with the server.
+type condition interface {
+	// check samples the condition and returns whether it is met
+	check(now time.Time) (conditionResult, error)
+}
+
+type conditionResult struct {
+	met      bool
+	reason   string
+	readings map[string]float64
+}
+
+// ConditionStatus reports the last readings and decision of a condition in Read.
+type ConditionStatus struct {
+	Name     string
+	Mode     string
+	Holding  bool
+	Reason   string
+	Readings map[string]float64
+	Checked  time.Time
+}
+
+// conditionMonitor holds a mode while its condition is met, and for cooldown
+// after it stopped being met so that short pauses do not let the computer sleep.
+type conditionMonitor struct {
+	name     string
+	cond     condition
+	flags    uint32
+	cooldown time.Duration
+
+	checkMu sync.Mutex // serializes evaluations, conditions are not safe for concurrent use
+	mu      sync.Mutex
+	lastMet time.Time
+	status  ConditionStatus
+}
+
+func newConditionMonitor(name string, cond condition, flags uint32, cooldown time.Duration) *conditionMonitor {
+	return &conditionMonitor{
+		name:     name,
+		cond:     cond,
+		flags:    flags,
+		cooldown: cooldown,
+		status:   ConditionStatus{Name: name, Mode: modeName(flags)},
+	}
+}
+
+// evaluate checks the condition and updates the manager's hold accordingly.
+func (c *conditionMonitor) evaluate(m *ExecStateManager) {
+	c.checkMu.Lock()
+	defer c.checkMu.Unlock()
+
+	now := m.now()
+	result, err := c.cond.check(now)
+
+	c.mu.Lock()
+	if err != nil {
+		log.Printf("Condition %s — check error: %v", c.name, err)
+		result.reason = "error: " + err.Error()
+	}
+	if result.met {
+		c.lastMet = now
+	}
+	holding := result.met || (!c.lastMet.IsZero() && now.Sub(c.lastMet) < c.cooldown)
+	if holding && !result.met {
+		result.reason += " (cooling down)"
+	}
+	if holding != c.status.Holding {
+		log.Printf("Condition %s — %s, holding: %v", c.name, result.reason, holding)
+	}
+	c.status.Holding = holding
+	c.status.Reason = result.reason
+	c.status.Readings = result.readings
+	c.status.Checked = now
+	c.mu.Unlock()
+
+	if holding {
+		err = m.hold(c.name, c.flags)
+	} else {
+		err = m.release(c.name)
+	}
+	if err != nil {
+		log.Printf("Condition %s — failed to update hold: %v", c.name, err)
+	}
+}
+
+func (c *conditionMonitor) getStatus() ConditionStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	status := c.status
+	status.Readings = maps.Clone(c.status.Readings)
+	return status
+}
+
+// addCondition registers a condition monitor, call before runConditions().
+func (m *ExecStateManager) addCondition(c *conditionMonitor) {
+	m.conditions = append(m.conditions, c)
+}
+
+// runConditions evaluates all conditions periodically until the manager stops.
+func (m *ExecStateManager) runConditions(interval time.Duration) {
+	if len(m.conditions) == 0 {
+		return
+	}
+	m.poll(interval, func() {
+		for _, c := range m.conditions {
+			c.evaluate(m)
+		}
+	})
+}
+
+func (m *ExecStateManager) getConditionStatus() []ConditionStatus {
+	var statuses []ConditionStatus
+	for _, c := range m.conditions {
+		statuses = append(statuses, c.getStatus())
+	}
+	return statuses
+}
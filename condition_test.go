@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeFixture writes files in a fake /proc or /sys tree under root, by path
+// relative to root, creating their directories.
+func writeFixture(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, contents := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// fakeCondition is met when its met field is set.
+type fakeCondition struct {
+	met bool
+}
+
+func (f *fakeCondition) check(now time.Time) (conditionResult, error) {
+	return conditionResult{met: f.met, reason: "fake"}, nil
+}
+
+func TestConditionMonitor(t *testing.T) {
+	now := time.Now()
+	manager := &ExecStateManager{clock: func() time.Time { return now }}
+	manager.Start()
+	defer manager.Stop()
+
+	if err := manager.setAtomicState(0, false, &ExecStateReply{}); err != nil {
+		t.Fatal(err)
+	}
+
+	cond := &fakeCondition{}
+	monitor := newConditionMonitor("fake", cond, ES_SYSTEM_REQUIRED, time.Minute)
+	manager.addCondition(monitor)
+
+	steps := []struct {
+		after   time.Duration
+		met     bool
+		holding bool
+	}{
+		{0, false, false},
+		{10 * time.Second, true, true},
+		{20 * time.Second, false, true}, // cooling down
+		{90 * time.Second, false, false},
+	}
+
+	for _, step := range steps {
+		now = now.Add(step.after)
+		cond.met = step.met
+		monitor.evaluate(manager)
+
+		status := manager.getConditionStatus()[0]
+		if status.Holding != step.holding {
+			t.Errorf("after %v: expected holding %v, got %v (%s)", step.after, step.holding, status.Holding, status.Reason)
+		}
+		manager.stateMu.Lock()
+		effective := manager.effectiveLocked()
+		manager.stateMu.Unlock()
+		if held := effective&ES_SYSTEM_REQUIRED != 0; held != step.holding {
+			t.Errorf("after %v: expected effective flags to hold the system, got 0x%X", step.after, effective)
+		}
+	}
+}
+
+func TestHoldSurvivesClear(t *testing.T) {
+	manager := &ExecStateManager{}
+	manager.Start()
+	defer manager.Stop()
+
+	if err := manager.hold("test", ES_SYSTEM_REQUIRED); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Clear(ExecStateRequest{}, &ExecStateReply{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The previous state returned by the next change is what Clear applied.
+	var reply ExecStateReply
+	if err := manager.Display(ExecStateRequest{}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Flags != ES_CONTINUOUS|ES_SYSTEM_REQUIRED {
+		t.Errorf("expected the hold to survive Clear, got 0x%X", reply.Flags)
+	}
+
+	if err := manager.release("test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Clear(ExecStateRequest{}, &ExecStateReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.System(ExecStateRequest{}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Flags != ES_CONTINUOUS {
+		t.Errorf("expected Clear to clear all flags once released, got 0x%X", reply.Flags)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"log"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// Default time to wait for the calls in progress on shutdown.
+const defaultDrainTimeout = 10 * time.Second
+
+// connCodec is the gob codec of net/rpc, extended to pass the details of the
+// connection to the RPC methods through their ExecStateRequest argument, which
+// net/rpc does not provide otherwise.
+type connCodec struct {
+	conn     net.Conn
+	dec      *gob.Decoder
+	enc      *gob.Encoder
+	encBuf   *bufio.Writer
+	closed   bool
+	remote   string
+	identity string
+
+	mu      sync.Mutex
+	handles []uint64 // handles taken by Hold on this connection
+}
+
+func newConnCodec(conn net.Conn) *connCodec {
+	buf := bufio.NewWriter(conn)
+	return &connCodec{
+		conn:     conn,
+		dec:      gob.NewDecoder(conn),
+		enc:      gob.NewEncoder(buf),
+		encBuf:   buf,
+		remote:   conn.RemoteAddr().String(),
+		identity: peerIdentity(conn),
+	}
+}
+
+func (c *connCodec) ReadRequestHeader(r *rpc.Request) error {
+	return c.dec.Decode(r)
+}
+
+func (c *connCodec) ReadRequestBody(body any) error {
+	if err := c.dec.Decode(body); err != nil {
+		return err
+	}
+	if req, ok := body.(*ExecStateRequest); ok {
+		req.remote = c.remote
+		req.identity = c.identity
+		req.conn = c
+	}
+	return nil
+}
+
+func (c *connCodec) WriteResponse(r *rpc.Response, body any) (err error) {
+	if err = c.enc.Encode(r); err != nil {
+		if c.encBuf.Flush() == nil {
+			log.Println("rpc: gob error encoding response:", err)
+			c.Close() //nolint:errcheck
+		}
+		return
+	}
+	if err = c.enc.Encode(body); err != nil {
+		if c.encBuf.Flush() == nil {
+			log.Println("rpc: gob error encoding body:", err)
+			c.Close() //nolint:errcheck
+		}
+		return
+	}
+	return c.encBuf.Flush()
+}
+
+// attach binds a handle to the connection.
+func (c *connCodec) attach(id uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handles = append(c.handles, id)
+}
+
+// detach returns and forgets the handles bound to the connection.
+func (c *connCodec) detach() []uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	handles := c.handles
+	c.handles = nil
+	return handles
+}
+
+func (c *connCodec) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.conn.Close()
+}
+
+// serveConn serves the RPC requests of a connection in a new goroutine, until
+// the client hangs up or the connections are drained. The handles taken by
+// Hold on the connection are released then: ServeCodec returns once the calls
+// in progress are done, so none can be attached after that.
+func (m *ExecStateManager) serveConn(conn net.Conn) {
+	codec := newConnCodec(conn)
+	m.connsMu.Lock()
+	if m.conns == nil {
+		m.conns = make(map[*connCodec]struct{})
+	}
+	m.conns[codec] = struct{}{}
+	m.connsMu.Unlock()
+	m.connsWG.Add(1)
+
+	go func() {
+		defer m.connsWG.Done()
+		rpc.ServeCodec(codec)
+		m.releaseConn(codec)
+
+		m.connsMu.Lock()
+		delete(m.conns, codec)
+		m.connsMu.Unlock()
+	}()
+}
+
+// drain notifies subscribers of the shutdown, stops reading requests from the
+// connections and waits up to timeout for the calls in progress to return.
+// The connections still open after that are closed.
+func (m *ExecStateManager) drain(timeout time.Duration) {
+	m.notifyShutdown()
+
+	m.connsMu.Lock()
+	log.Printf("Draining %d connection(s)", len(m.conns))
+	for c := range m.conns {
+		// ServeCodec stops reading, then waits for its calls in progress
+		c.conn.SetReadDeadline(time.Now()) //nolint:errcheck
+	}
+	m.connsMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.connsWG.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		log.Println("All connections drained")
+	case <-timer.C:
+		m.connsMu.Lock()
+		log.Printf("Drain timeout, closing %d connection(s)", len(m.conns))
+		for c := range m.conns {
+			c.conn.Close() //nolint:errcheck
+		}
+		m.connsMu.Unlock()
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "net"
+
+// peerIdentity returns "", peer credentials are only available on Linux.
+func peerIdentity(conn net.Conn) string {
+	return ""
+}
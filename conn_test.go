@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveTestConns accepts connections on a random port for the manager, and
+// returns the listener and a function dialing a new client.
+func serveTestConns(t *testing.T, manager *ExecStateManager) (net.Listener, func() *rpc.Client) {
+	t.Helper()
+	rpc.DefaultServer = rpc.NewServer()
+	if err := rpc.Register(manager); err != nil {
+		t.Fatalf("rpc.Register failed: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			manager.serveConn(conn)
+		}
+	}()
+	dial := func() *rpc.Client {
+		client, err := rpc.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to dial RPC server: %v", err)
+		}
+		return client
+	}
+	return listener, dial
+}
+
+func TestDrain(t *testing.T) {
+	manager := &ExecStateManager{}
+	manager.Start()
+	defer manager.Stop()
+	listener, dial := serveTestConns(t, manager)
+
+	client := dial()
+	defer client.Close()
+	idle := dial()
+	defer idle.Close()
+
+	var readReply ExecStateReply
+	if err := client.Call("ExecStateManager.Read", ExecStateRequest{}, &readReply); err != nil {
+		t.Fatalf("Read RPC call failed: %v", err)
+	}
+	watch := client.Go("ExecStateManager.Watch", ExecStateRequest{Cursor: readReply.Cursor, Wait: time.Minute}, &ExecStateReply{}, nil)
+	time.Sleep(10 * time.Millisecond)
+
+	listener.Close()
+	start := time.Now()
+	manager.drain(time.Minute)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the connections to drain quickly, took %v", elapsed)
+	}
+
+	// the subscriber got the shutdown event before its connection was closed
+	<-watch.Done
+	if watch.Error != nil {
+		t.Fatalf("Watch RPC call failed: %v", watch.Error)
+	}
+	if events := watch.Reply.(*ExecStateReply).Events; len(events) != 1 || events[0].Type != EventShutdown {
+		t.Errorf("expected the shutdown event, got %v", events)
+	}
+	if err := idle.Call("ExecStateManager.Read", ExecStateRequest{}, &ExecStateReply{}); err == nil {
+		t.Error("expected the idle connection to be closed")
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	release := make(chan struct{})
+	manager := &ExecStateManager{
+		setState: func(flags uint32) (uint32, error) {
+			if flags != ES_CONTINUOUS {
+				<-release
+			}
+			return ES_CONTINUOUS, nil
+		},
+	}
+	manager.Start()
+	defer manager.Stop()
+	defer close(release)
+	listener, dial := serveTestConns(t, manager)
+
+	client := dial()
+	defer client.Close()
+
+	call := client.Go("ExecStateManager.Display", ExecStateRequest{}, &ExecStateReply{}, nil)
+	time.Sleep(10 * time.Millisecond)
+
+	listener.Close()
+	manager.drain(50 * time.Millisecond)
+
+	select {
+	case <-call.Done:
+		if call.Error == nil {
+			t.Error("expected the call to fail when its connection is closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the connection was not closed after the drain timeout")
+	}
+}
+
+func TestServeError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nosleep.sock")
+	cfg := &Config{network: "unix", address: path, watches: stringList{"backup=clear"}}
+
+	// the watch rule is invalid, which is found after the initial state is applied
+	err := serve(cfg)
+	if err == nil || !strings.Contains(err.Error(), "invalid watch rule") {
+		t.Fatalf("expected an invalid watch rule error, got %v", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected the socket file removed, got %v", err)
+	}
+	if runtime.GOOS != "windows" {
+		// the backend emulates a process-wide state, expected cleared by Stop
+		if previous, _ := SetThreadExecutionState(ES_CONTINUOUS); previous != ES_CONTINUOUS {
+			t.Errorf("expected the state cleared, got 0x%08X", previous)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/rpc"
+	"strings"
+)
+
+// ErrorCode tells clients what kind of error an RPC call failed with.
+//
+// net/rpc only transmits the error string, and drops the reply of a call that
+// failed. The code is carried as a prefix of the message, like "not-found:
+// process 1234 is not registered", and ErrorCodeOf extracts it on the client
+// side. The HTTP endpoints, which keep the reply, return it in the Code field
+// of ExecStateReply with the matching HTTP status.
+type ErrorCode string
+
+const (
+	ErrBackend      ErrorCode = "backend"       // the execution state could not be applied
+	ErrNotFound     ErrorCode = "not-found"     // unknown process, handle or schedule rule
+	ErrInvalid      ErrorCode = "invalid"       // invalid argument, eg. an unknown mode
+	ErrDenied       ErrorCode = "denied"        // caller not allowed, eg. invalid token
+	ErrShuttingDown ErrorCode = "shutting-down" // server stopping, or connection closed
+	ErrUnsupported  ErrorCode = "unsupported"   // feature not enabled on this server
+	ErrUnavailable  ErrorCode = "unavailable"   // upstream unreachable or timed out
+	ErrInternal     ErrorCode = "internal"      // any other error
+)
+
+var errorCodes = []ErrorCode{ErrBackend, ErrNotFound, ErrInvalid, ErrDenied, ErrShuttingDown, ErrUnsupported, ErrUnavailable, ErrInternal}
+
+// Error is an error with a code, returned by the RPC methods.
+type Error struct {
+	Code ErrorCode
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return string(e.Code) + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// newError returns an error with a code and a formatted message.
+func newError(code ErrorCode, format string, args ...any) error {
+	return &Error{Code: code, Err: fmt.Errorf(format, args...)}
+}
+
+// withCode gives a code to err, unless it has one already or is nil.
+func withCode(code ErrorCode, err error) error {
+	var coded *Error
+	if err == nil || errors.As(err, &coded) {
+		return err
+	}
+	return &Error{Code: code, Err: err}
+}
+
+// ErrorCodeOf returns the code of an error returned by an RPC call (on either
+// side of the connection), ErrInternal if it has none and "" if err is nil.
+func ErrorCodeOf(err error) ErrorCode {
+	var coded *Error
+	var serverErr rpc.ServerError
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &coded):
+		return coded.Code
+	case errors.Is(err, rpc.ErrShutdown):
+		return ErrShuttingDown
+	case errors.As(err, &serverErr):
+		if prefix, _, ok := strings.Cut(string(serverErr), ": "); ok {
+			for _, code := range errorCodes {
+				if prefix == string(code) {
+					return code
+				}
+			}
+		}
+	}
+	return ErrInternal
+}
+
+// HTTPStatus returns the HTTP status of an error with this code.
+func (c ErrorCode) HTTPStatus() int {
+	switch c {
+	case ErrBackend:
+		return http.StatusBadGateway
+	case ErrNotFound:
+		return http.StatusNotFound
+	case ErrInvalid:
+		return http.StatusBadRequest
+	case ErrDenied:
+		return http.StatusForbidden
+	case ErrShuttingDown:
+		return http.StatusServiceUnavailable
+	case ErrUnavailable:
+		return http.StatusGatewayTimeout
+	case ErrUnsupported:
+		return http.StatusNotImplemented
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"testing"
+)
+
+func TestErrorCodes(t *testing.T) {
+	manager := &ExecStateManager{
+		setState: func(flags uint32) (uint32, error) {
+			if flags&ES_DISPLAY_REQUIRED != 0 {
+				return 0, errors.New("display unavailable")
+			}
+			return ES_CONTINUOUS, nil
+		},
+	}
+	manager.Start()
+	listener, dial := serveTestConns(t, manager)
+	defer listener.Close()
+	client := dial()
+	defer client.Close()
+
+	tests := []struct {
+		method string
+		req    ExecStateRequest
+		want   ErrorCode
+	}{
+		{"Unregister", ExecStateRequest{Process: 1234}, ErrNotFound},
+		{"Release", ExecStateRequest{Handle: 99}, ErrNotFound},
+		{"RemoveSchedule", ExecStateRequest{RuleID: 7}, ErrNotFound},
+		{"Acquire", ExecStateRequest{Mode: "turbo"}, ErrInvalid},
+		{"AddSchedule", ExecStateRequest{Rule: "someday"}, ErrInvalid},
+		{"QueryAudit", ExecStateRequest{}, ErrUnsupported},
+		{"Display", ExecStateRequest{}, ErrBackend},
+		{"System", ExecStateRequest{}, ""},
+	}
+	for _, tt := range tests {
+		err := client.Call("ExecStateManager."+tt.method, tt.req, &ExecStateReply{})
+		if got := ErrorCodeOf(err); got != tt.want {
+			t.Errorf("%s: expected code %q, got %q (%v)", tt.method, tt.want, got, err)
+		}
+	}
+
+	manager.Stop()
+	err := client.Call("ExecStateManager.Critical", ExecStateRequest{}, &ExecStateReply{})
+	if got := ErrorCodeOf(err); got != ErrShuttingDown {
+		t.Errorf("expected code %q after Stop, got %q (%v)", ErrShuttingDown, got, err)
+	}
+	client.Close()
+	err = client.Call("ExecStateManager.Read", ExecStateRequest{}, &ExecStateReply{})
+	if got := ErrorCodeOf(err); got != ErrShuttingDown {
+		t.Errorf("expected code %q for a closed connection, got %q (%v)", ErrShuttingDown, got, err)
+	}
+}
+
+func TestErrorCodeOf(t *testing.T) {
+	tests := []struct {
+		err  error
+		want ErrorCode
+	}{
+		{nil, ""},
+		{newError(ErrDenied, "go away"), ErrDenied},
+		{withCode(ErrInternal, newError(ErrBackend, "failed")), ErrBackend},
+		{rpc.ServerError("not-found: no handle with ID 3"), ErrNotFound},
+		{rpc.ServerError("no prefix: here"), ErrInternal},
+		{errors.New("plain"), ErrInternal},
+	}
+	for _, tt := range tests {
+		if got := ErrorCodeOf(tt.err); got != tt.want {
+			t.Errorf("ErrorCodeOf(%v): expected %q, got %q", tt.err, tt.want, got)
+		}
+	}
+	if ErrNotFound.HTTPStatus() != http.StatusNotFound || ErrInternal.HTTPStatus() != http.StatusInternalServerError {
+		t.Error("unexpected HTTP status mapping")
+	}
+}
+
+func TestHTTPError(t *testing.T) {
+	w := httptest.NewRecorder()
+	httpError(w, newError(ErrNotFound, "process %d is not registered", 1234))
+
+	var reply ExecStateReply
+	if err := json.NewDecoder(w.Body).Decode(&reply); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusNotFound || reply.Code != ErrNotFound || reply.Error != "not-found: process 1234 is not registered" {
+		t.Errorf("expected a not-found reply with 404, got %d %+v", w.Code, reply)
+	}
+}
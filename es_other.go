@@ -0,0 +1,13 @@
+//go:build !windows
+
+package main
+
+// Execution state flags, with the values of the Windows API so that the RPC
+// protocol is the same on every platform. See win_kernel32.go.
+const (
+	ES_AWAYMODE_REQUIRED = 0x00000040
+	ES_CONTINUOUS        = 0x80000000
+	ES_DISPLAY_REQUIRED  = 0x00000002
+	ES_SYSTEM_REQUIRED   = 0x00000001
+	ES_USER_PRESENT      = 0x00000004
+)
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Number of events kept for subscribers that fall behind.
+const maxEvents = 256
+
+// Event types
+const (
+	EventMode       = "mode"
+	EventRegister   = "register"
+	EventUnregister = "unregister"
+	EventHold       = "hold"
+	EventRelease    = "release"
+	EventExpire     = "expire"
+	EventShutdown   = "shutdown"
+	EventDrift      = "drift"
+)
+
+// Event is a state change pushed to subscribers. Seq increases by one with
+// each event and is used as a cursor to resume a subscription.
+type Event struct {
+	Seq     uint64
+	Time    time.Time
+	Type    string
+	Flags   uint32 // for mode and hold events, state found for drift events
+	Process int    // for register and unregister events
+	Detail  string
+}
+
+// eventLog keeps the last events and wakes up subscribers when one is published.
+type eventLog struct {
+	mu      sync.Mutex
+	seq     uint64
+	events  []Event
+	changed chan struct{} // closed and replaced when an event is published
+}
+
+// publish assigns the next sequence number to the event and records it.
+func (l *eventLog) publish(e Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.seq++
+	e.Seq = l.seq
+	l.events = append(l.events, e)
+	if len(l.events) > maxEvents {
+		l.events = slices.Delete(l.events, 0, len(l.events)-maxEvents)
+	}
+	if l.changed != nil {
+		close(l.changed)
+		l.changed = nil
+	}
+}
+
+// since returns the events after cursor, and a channel that is closed when the
+// next event is published. Events that were dropped from the log are skipped,
+// and a cursor from the future (eg. before a restart) starts over.
+func (l *eventLog) since(cursor uint64) ([]Event, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if cursor > l.seq {
+		cursor = 0
+	}
+	if l.changed == nil {
+		l.changed = make(chan struct{})
+	}
+	i, _ := slices.BinarySearchFunc(l.events, cursor+1, func(e Event, seq uint64) int {
+		return cmp.Compare(e.Seq, seq)
+	})
+	return slices.Clone(l.events[i:]), l.changed
+}
+
+// cursor returns the sequence number of the last event.
+func (l *eventLog) cursor() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.seq
+}
+
+// publish records an event for subscribers and in the history.
+func (m *ExecStateManager) publish(e Event) {
+	e.Time = m.now()
+	m.events.publish(e)
+	m.history.observe(e)
+}
+
+// waitEvents returns the events after cursor, waiting up to timeout for one to
+// be published. It returns early when the manager stops.
+func (m *ExecStateManager) waitEvents(cursor uint64, timeout time.Duration) []Event {
+	events, changed := m.events.since(cursor)
+	if len(events) > 0 {
+		return events
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-changed:
+	case <-timer.C:
+	case <-m.mgrShutdownCh:
+	}
+	events, _ = m.events.since(cursor)
+	return events
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventLog(t *testing.T) {
+	var l eventLog
+	for i := 0; i < maxEvents+10; i++ {
+		l.publish(Event{Type: EventRegister, Process: i})
+	}
+
+	events, _ := l.since(0)
+	if len(events) != maxEvents || events[0].Seq != 11 {
+		t.Fatalf("expected the last %d events from seq 11, got %d from seq %d", maxEvents, len(events), events[0].Seq)
+	}
+	if events, _ = l.since(l.cursor() - 2); len(events) != 2 || events[1].Seq != l.cursor() {
+		t.Errorf("expected the last 2 events, got %v", events)
+	}
+	if events, _ = l.since(l.cursor() + 100); len(events) != maxEvents {
+		t.Errorf("expected a cursor from the future to start over, got %d events", len(events))
+	}
+}
+
+func TestRPCWatch(t *testing.T) {
+	manager, listener, client := setupTestServer(t)
+	defer listener.Close()
+	defer client.Close()
+	defer manager.Stop()
+
+	var readReply ExecStateReply
+	if err := client.Call("ExecStateManager.Read", ExecStateRequest{}, &readReply); err != nil {
+		t.Fatalf("Read RPC call failed: %v", err)
+	}
+
+	// nothing happens
+	var reply ExecStateReply
+	if err := client.Call("ExecStateManager.Watch", ExecStateRequest{Cursor: readReply.Cursor, Wait: 10 * time.Millisecond}, &reply); err != nil {
+		t.Fatalf("Watch RPC call failed: %v", err)
+	}
+	if len(reply.Events) != 0 || reply.Cursor != readReply.Cursor {
+		t.Fatalf("expected no events, got %v (cursor %d)", reply.Events, reply.Cursor)
+	}
+
+	// the watch returns as soon as a process registers
+	done := make(chan *ExecStateReply)
+	go func() {
+		var reply ExecStateReply
+		if err := client.Call("ExecStateManager.Watch", ExecStateRequest{Cursor: readReply.Cursor, Wait: time.Minute}, &reply); err != nil {
+			t.Errorf("Watch RPC call failed: %v", err)
+		}
+		done <- &reply
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err := client.Call("ExecStateManager.Register", ExecStateRequest{Process: 42}, &ExecStateReply{}); err != nil {
+		t.Fatalf("Register RPC call failed: %v", err)
+	}
+
+	select {
+	case r := <-done:
+		if len(r.Events) != 1 || r.Events[0].Type != EventRegister || r.Events[0].Process != 42 {
+			t.Errorf("expected a register event for pid 42, got %v", r.Events)
+		}
+		if r.Cursor != r.Events[len(r.Events)-1].Seq {
+			t.Errorf("expected the cursor to be the last event, got %d", r.Cursor)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Watch did not return after Register")
+	}
+}
+
+func TestServeEvents(t *testing.T) {
+	manager := &ExecStateManager{}
+	manager.Start()
+
+	server := httptest.NewServer(manager.httpHandler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %q", ct)
+	}
+
+	if err := manager.System(ExecStateRequest{}, &ExecStateReply{}); err != nil {
+		t.Fatal(err)
+	}
+	manager.Stop()
+
+	// the stream ends after the shutdown event
+	var types []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if eventType, ok := strings.CutPrefix(scanner.Text(), "event: "); ok {
+			types = append(types, eventType)
+		}
+	}
+	if strings.Join(types, ",") != "mode,shutdown" {
+		t.Errorf("expected mode and shutdown events, got %v", types)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Largest hold file that is read to look for a TTL.
+const maxHoldFileSize = 64
+
+// FileHold is a file in the hold directory. Expires is zero when the file has no TTL.
+type FileHold struct {
+	Name     string
+	Modified time.Time
+	Expires  time.Time
+}
+
+// fileHoldCondition is met while the hold directory contains files, so that
+// scripts can keep the computer awake by touching a file and deleting it when
+// they are done.
+//
+// A file may carry a TTL, either as a duration in its contents ("2h") that
+// starts at its modification time, or as a modification time in the future
+// (touch -d "+2 hours"). Expired files and files older than maxAge are ignored.
+type fileHoldCondition struct {
+	dir      string
+	maxAge   time.Duration
+	onExpire func(name string) // called once when a file expires
+
+	mu      sync.Mutex
+	holds   []FileHold
+	ignored map[string]bool // files that were logged as ignored
+}
+
+func (f *fileHoldCondition) check(now time.Time) (conditionResult, error) {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return conditionResult{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.ignored == nil {
+		f.ignored = make(map[string]bool)
+	}
+	seen := make(map[string]bool)
+	var holds []FileHold
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // deleted in the meantime
+		}
+		seen[entry.Name()] = true
+
+		hold := FileHold{Name: entry.Name(), Modified: info.ModTime()}
+		if reason := f.inspect(&hold, info.Size(), now); reason != "" {
+			if !f.ignored[hold.Name] {
+				log.Printf("Hold file %s ignored: %s", filepath.Join(f.dir, hold.Name), reason)
+				f.ignored[hold.Name] = true
+				if !hold.Expires.IsZero() && f.onExpire != nil {
+					f.onExpire(hold.Name)
+				}
+			}
+			continue
+		}
+		delete(f.ignored, hold.Name)
+		holds = append(holds, hold)
+	}
+	for name := range f.ignored {
+		if !seen[name] {
+			delete(f.ignored, name)
+		}
+	}
+	f.holds = holds
+
+	result := conditionResult{
+		met:      len(holds) > 0,
+		readings: map[string]float64{"files": float64(len(holds))},
+	}
+	if result.met {
+		result.reason = fmt.Sprintf("%d hold file(s) in %s", len(holds), f.dir)
+	} else {
+		result.reason = "no hold file in " + f.dir
+	}
+	return result, nil
+}
+
+// inspect sets the expiry of a hold file and returns why it should be ignored, if so.
+func (f *fileHoldCondition) inspect(hold *FileHold, size int64, now time.Time) string {
+	if hold.Modified.After(now) {
+		hold.Expires = hold.Modified
+		return ""
+	}
+	if size > 0 && size <= maxHoldFileSize {
+		data, err := os.ReadFile(filepath.Join(f.dir, hold.Name))
+		if err == nil {
+			if ttl, err := time.ParseDuration(strings.TrimSpace(string(data))); err == nil {
+				hold.Expires = hold.Modified.Add(ttl)
+			}
+		}
+	}
+	switch {
+	case !hold.Expires.IsZero() && !now.Before(hold.Expires):
+		return fmt.Sprintf("expired at %s", hold.Expires.Format(time.DateTime))
+	case f.maxAge > 0 && now.Sub(hold.Modified) > f.maxAge:
+		return fmt.Sprintf("stale, last modified at %s", hold.Modified.Format(time.DateTime))
+	}
+	return ""
+}
+
+func (f *fileHoldCondition) getHolds() []FileHold {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return slices.Clone(f.holds)
+}
+
+// getFileHolds returns the files currently holding the computer awake.
+func (m *ExecStateManager) getFileHolds() []FileHold {
+	var holds []FileHold
+	for _, c := range m.conditions {
+		if f, ok := c.cond.(*fileHoldCondition); ok {
+			holds = append(holds, f.getHolds()...)
+		}
+	}
+	return holds
+}
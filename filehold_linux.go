@@ -0,0 +1,42 @@
+//go:build linux
+
+package main
+
+import (
+	"log"
+	"os"
+	"syscall"
+)
+
+// watchHoldDir re-evaluates the file hold condition as soon as the hold
+// directory changes, so that creating or deleting a file takes effect
+// without waiting for the next poll.
+func (m *ExecStateManager) watchHoldDir(dir string, monitor *conditionMonitor) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		log.Printf("inotify unavailable, polling %s: %v", dir, err)
+		return
+	}
+	mask := uint32(syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_TO |
+		syscall.IN_MOVED_FROM | syscall.IN_CLOSE_WRITE | syscall.IN_ATTRIB)
+	if _, err := syscall.InotifyAddWatch(fd, dir, mask); err != nil {
+		syscall.Close(fd) //nolint:errcheck
+		log.Printf("Cannot watch %s, polling instead: %v", dir, err)
+		return
+	}
+
+	// a non-blocking file goes through the runtime poller, so Close() unblocks Read()
+	events := os.NewFile(uintptr(fd), "inotify")
+	go func() {
+		<-m.mgrShutdownCh
+		events.Close() //nolint:errcheck
+	}()
+
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		if _, err := events.Read(buf); err != nil {
+			return
+		}
+		monitor.evaluate(m)
+	}
+}
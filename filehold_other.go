@@ -0,0 +1,6 @@
+//go:build !linux
+
+package main
+
+// watchHoldDir does nothing, the hold directory is polled with the other conditions.
+func (m *ExecStateManager) watchHoldDir(dir string, monitor *conditionMonitor) {}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileHoldCondition(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	files := &fileHoldCondition{dir: dir, maxAge: 24 * time.Hour}
+
+	writeHoldFile := func(name, contents string, modified time.Time) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if result, err := files.check(now); err != nil || result.met {
+		t.Fatalf("expected an empty directory not to hold: %+v, %v", result, err)
+	}
+
+	writeHoldFile("backup", "", now.Add(-time.Hour))
+	writeHoldFile("ttl", "2h\n", now.Add(-time.Hour))
+	writeHoldFile("future", "", now.Add(30*time.Minute))
+	writeHoldFile("expired", "30m", now.Add(-time.Hour))
+	writeHoldFile("stale", "", now.Add(-48*time.Hour))
+	writeHoldFile(".hidden", "", now)
+
+	result, err := files.check(now)
+	if err != nil || !result.met {
+		t.Fatalf("expected hold files to hold: %+v, %v", result, err)
+	}
+
+	holds := make(map[string]FileHold)
+	for _, h := range files.getHolds() {
+		holds[h.Name] = h
+	}
+	if len(holds) != 3 {
+		t.Fatalf("expected backup, ttl and future to hold, got %v", holds)
+	}
+	if !holds["backup"].Expires.IsZero() {
+		t.Errorf("expected backup not to expire, got %v", holds["backup"].Expires)
+	}
+	if want := now.Add(time.Hour).Truncate(time.Second); !holds["ttl"].Expires.Truncate(time.Second).Equal(want) {
+		t.Errorf("expected ttl to expire at %v, got %v", want, holds["ttl"].Expires)
+	}
+	if !holds["future"].Expires.Equal(holds["future"].Modified) {
+		t.Errorf("expected future to expire at its modification time, got %v", holds["future"].Expires)
+	}
+	if !files.ignored["expired"] || !files.ignored["stale"] {
+		t.Errorf("expected expired and stale files to be ignored, got %v", files.ignored)
+	}
+
+	// deleting the files releases the hold
+	for _, name := range []string{"backup", "ttl", "future"} {
+		os.Remove(filepath.Join(dir, name))
+	}
+	if result, _ = files.check(now); result.met {
+		t.Errorf("expected the hold to be released: %+v", result)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Default time a fleet command waits for each server.
+const defaultFleetTimeout = 5 * time.Second
+
+// RPC methods of the fleet commands.
+var fleetMethods = map[string]string{
+	"system":     "System",
+	"display":    "Display",
+	"clear":      "Clear",
+	"register":   "Register",
+	"unregister": "Unregister",
+	"read":       "Read",
+}
+
+// readInventory reads the servers of an inventory file, one per line as
+// "name=host:port" or "host:port" (named after its address), with the options
+// of --upstream. Blank lines and lines starting with # are ignored.
+func readInventory(path string) ([]Upstream, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() //nolint:errcheck
+
+	var servers []Upstream
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		if address, _, _ := strings.Cut(s, "?"); !strings.Contains(address, "=") {
+			s = address + "=" + s
+		}
+		server, err := parseUpstream(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		if slices.ContainsFunc(servers, func(u Upstream) bool { return u.Name == server.Name }) {
+			return nil, fmt.Errorf("%s:%d: duplicate server %q", path, line, server.Name)
+		}
+		servers = append(servers, server)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("%s: no server", path)
+	}
+	return servers, nil
+}
+
+// writeFleetSummary prints the result of a fleet command calling method for
+// each server, and returns the number of servers that failed.
+func writeFleetSummary(w io.Writer, method string, results []UpstreamResult) int {
+	failed := 0
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVER\tADDRESS\tSTATUS\tFLAGS\tPROCESSES\tHEALTH\tELAPSED\tERROR")
+	for _, r := range results {
+		if r.Reply == nil {
+			failed++
+			fmt.Fprintf(tw, "%s\t%s\tfailed\t-\t-\t-\t%s\t%s\n", r.Name, r.Address, r.Elapsed.Round(time.Millisecond), r.Error)
+			continue
+		}
+		processes, health := "-", "-"
+		if method == "Read" && r.Reply.Health != nil {
+			processes, health = strconv.Itoa(len(r.Reply.Processes)), r.Reply.Health.Status
+		}
+		fmt.Fprintf(tw, "%s\t%s\tok\t0x%08X\t%s\t%s\t%s\t\n", r.Name, r.Address, r.Reply.Flags, processes, health, r.Elapsed.Round(time.Millisecond))
+	}
+	tw.Flush() //nolint:errcheck
+	return failed
+}
+
+// writeFleetTotal prints the combined state of the servers of a fleet read,
+// like a Read forwarded by a relay.
+func writeFleetTotal(w io.Writer, results []UpstreamResult) {
+	var total ExecStateReply
+	aggregateRead(results, &total)
+	fmt.Fprintf(w, "All servers: %s mode (0x%08X), %d registered process(es), health %s\n",
+		modeName(total.Applied), total.Applied, len(total.Registrations), total.Health.Status)
+}
+
+// fleetCommand runs a command on all the servers of an inventory file
+// concurrently, prints a summary and fails if any server failed. The --tls-ca
+// and --token options apply to the servers without options of their own.
+func fleetCommand(cfg *Config, args []string) int {
+	fs := flag.NewFlagSet("fleet", flag.ContinueOnError)
+	timeout := fs.Duration("timeout", defaultFleetTimeout, "how long to wait for each server")
+	client := fs.String("client", "fleet", "client name recorded in the audit logs of the servers")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: "+name+" fleet [OPTIONS] FILE system|display|clear|read\n       "+name+
+			" fleet [OPTIONS] FILE register|unregister PID\n\nRuns a command on all the servers listed in FILE (one name=host:port or host:port per line,\nwith the options of --upstream). --tls-ca and --token apply to the servers without options.\n\nOPTIONS:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return 2
+	}
+	command := fs.Arg(1)
+	method, ok := fleetMethods[command]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown fleet command %q (expected system, display, clear, register, unregister or read)\n", command)
+		return 2
+	}
+	req := ExecStateRequest{Client: *client}
+	switch {
+	case command == "register" || command == "unregister":
+		if fs.NArg() != 3 {
+			fs.Usage()
+			return 2
+		}
+		pid, err := strconv.Atoi(fs.Arg(2))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid PID %q\n", fs.Arg(2))
+			return 2
+		}
+		req.Process = pid
+	case fs.NArg() != 2:
+		fs.Usage()
+		return 2
+	}
+
+	servers, err := readInventory(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for i := range servers {
+		options := &servers[i].options
+		if options.tlsCA == "" && cfg.tlsCA != "" {
+			options.tlsCA = cfg.tlsCA
+			options.serverName, _, _ = net.SplitHostPort(servers[i].Address)
+		}
+		if options.token == "" {
+			options.token = cfg.token
+		}
+	}
+	results := forward(servers, method, req, *timeout)
+	failed := writeFleetSummary(os.Stdout, method, results)
+	if command == "read" {
+		writeFleetTotal(os.Stdout, results)
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d server(s) failed\n", failed, len(servers))
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadInventory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rack.txt")
+	content := "# rack 4\nnode1=10.0.4.1:9001\n\n10.0.4.2:9001\nlocal=unix:/run/nosleep.sock\n10.0.4.3:9001?token=s3cret\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	servers, err := readInventory(path)
+	if err != nil {
+		t.Fatalf("readInventory failed: %v", err)
+	}
+	want := []Upstream{
+		{Name: "node1", Network: "tcp", Address: "10.0.4.1:9001"},
+		{Name: "10.0.4.2:9001", Network: "tcp", Address: "10.0.4.2:9001"},
+		{Name: "local", Network: "unix", Address: "/run/nosleep.sock"},
+		{Name: "10.0.4.3:9001", Network: "tcp", Address: "10.0.4.3:9001", options: dialOptions{token: "s3cret"}},
+	}
+	if len(servers) != len(want) {
+		t.Fatalf("expected %v, got %v", want, servers)
+	}
+	for i := range want {
+		if servers[i] != want[i] {
+			t.Errorf("expected %v, got %v", want[i], servers[i])
+		}
+	}
+
+	for _, bad := range []string{"", "# empty\n", "node1=10.0.4.1:9001\nnode1=10.0.4.2:9001\n", "=10.0.4.1:9001\n"} {
+		if err := os.WriteFile(path, []byte(bad), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readInventory(path); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestFleet(t *testing.T) {
+	var servers []Upstream
+	var managers []*ExecStateManager
+	for _, name := range []string{"node1", "node2", "node3"} {
+		manager := &ExecStateManager{keepOnEmpty: true}
+		manager.Start()
+		defer manager.Stop()
+		servers = append(servers, Upstream{Name: name, Network: "tcp", Address: serveUpstream(t, manager)})
+		managers = append(managers, manager)
+	}
+
+	results := forward(servers, fleetMethods["display"], ExecStateRequest{Client: "fleet"}, time.Second)
+	var out bytes.Buffer
+	if failed := writeFleetSummary(&out, "Display", results); failed != 0 {
+		t.Fatalf("expected all the servers to succeed:\n%s", out.String())
+	}
+	// only Read returns the processes and health
+	if fields := strings.Fields(strings.Split(out.String(), "\n")[1]); fields[4] != "-" || fields[5] != "-" {
+		t.Errorf("expected no processes and health for Display, got %v", fields)
+	}
+	for i, manager := range managers {
+		if flags := manager.appliedFlags(); flags != ES_SYSTEM_REQUIRED|ES_DISPLAY_REQUIRED {
+			t.Errorf("expected the display mode on %s, got %#x", servers[i].Name, flags)
+		}
+	}
+
+	// a server that does not answer in time
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	servers = append(servers, Upstream{Name: "hung", Network: "tcp", Address: silent.Addr().String()})
+
+	start := time.Now()
+	results = forward(servers, fleetMethods["read"], ExecStateRequest{}, 200*time.Millisecond)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected the per-server timeout to apply, took %v", elapsed)
+	}
+	out.Reset()
+	if failed := writeFleetSummary(&out, "Read", results); failed != 1 {
+		t.Errorf("expected one failure, got %d:\n%s", failed, out.String())
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 5 || strings.Fields(lines[1])[2] != "ok" || strings.Fields(lines[1])[5] != HealthOK || !strings.Contains(lines[4], "unavailable") {
+		t.Errorf("unexpected summary:\n%s", out.String())
+	}
+
+	out.Reset()
+	writeFleetTotal(&out, results)
+	if want := "All servers: display mode (0x00000003), 0 registered process(es), health stopped\n"; out.String() != want {
+		t.Errorf("expected %q, got %q", want, out.String())
+	}
+}
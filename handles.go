@@ -0,0 +1,243 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default number of heartbeats a lease may miss before it is dropped, and
+// interval at which leases are checked.
+const (
+	defaultMaxMissed   = 3
+	leaseCheckInterval = time.Second
+)
+
+// Handle is a counted request for a mode, returned by Acquire. The mode stays
+// in effect until all the handles requesting it are released, whatever the
+// other callers do.
+//
+// A handle acquired with a heartbeat interval is a lease: it must be renewed
+// by Heartbeat calls and is dropped after MaxMissed missed beats, so that a
+// remote client that crashed or lost the network does not keep the computer
+// awake. Expires is zero for handles without heartbeat.
+//
+// A handle taken by Hold is bound to the connection of the caller, whose
+// remote address is in Conn, and is released when that connection closes.
+type Handle struct {
+	ID        uint64
+	Mode      string
+	Client    string
+	Conn      string
+	Acquired  time.Time
+	Heartbeat time.Duration
+	MaxMissed int
+	LastBeat  time.Time
+	Expires   time.Time
+}
+
+// renew records a heartbeat at now and moves the expiry accordingly.
+func (h *Handle) renew(now time.Time) {
+	h.LastBeat = now
+	h.Expires = now.Add(h.Heartbeat * time.Duration(h.MaxMissed))
+}
+
+// handleTable keeps the handles that were acquired and not released yet.
+type handleTable struct {
+	mu      sync.Mutex
+	next    uint64
+	handles map[uint64]Handle
+}
+
+func (t *handleTable) add(h Handle) Handle {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.handles == nil {
+		t.handles = make(map[uint64]Handle)
+	}
+	t.next++
+	h.ID = t.next
+	t.handles[h.ID] = h
+	return h
+}
+
+// heartbeat renews a lease.
+func (t *handleTable) heartbeat(id uint64, now time.Time) (Handle, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	h, ok := t.handles[id]
+	switch {
+	case !ok:
+		return h, newError(ErrNotFound, "no handle with ID %d", id)
+	case h.Heartbeat == 0:
+		return h, newError(ErrInvalid, "handle %d has no heartbeat", id)
+	}
+	h.renew(now)
+	t.handles[id] = h
+	return h, nil
+}
+
+// removeExpired removes and returns the leases that expired at now.
+func (t *handleTable) removeExpired(now time.Time) []Handle {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var expired []Handle
+	for id, h := range t.handles {
+		if !h.Expires.IsZero() && !now.Before(h.Expires) {
+			expired = append(expired, h)
+			delete(t.handles, id)
+		}
+	}
+	return expired
+}
+
+func (t *handleTable) remove(id uint64) (Handle, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	h, ok := t.handles[id]
+	delete(t.handles, id)
+	return h, ok
+}
+
+// list returns the handles by ID.
+func (t *handleTable) list() []Handle {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return slices.SortedFunc(maps.Values(t.handles), func(a, b Handle) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+}
+
+// refcounts returns the number of handles per mode.
+func (t *handleTable) refcounts() map[string]int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	counts := make(map[string]int)
+	for _, h := range t.handles {
+		counts[h.Mode]++
+	}
+	return counts
+}
+
+// handleOwner returns the hold owner of a handle.
+func handleOwner(id uint64) string {
+	return fmt.Sprintf("handle:%d", id)
+}
+
+// acquire takes a handle on a mode, held on top of the mode set by the force
+// methods. With a heartbeat interval, the handle is a lease.
+func (m *ExecStateManager) acquire(mode, client string, heartbeat time.Duration, maxMissed int) (Handle, error) {
+	flags, err := parseMode(mode)
+	if err != nil {
+		return Handle{}, withCode(ErrInvalid, err)
+	}
+	if flags == 0 {
+		return Handle{}, newError(ErrInvalid, "cannot acquire the clear mode, release the handles instead")
+	}
+	if heartbeat < 0 {
+		return Handle{}, newError(ErrInvalid, "invalid heartbeat interval")
+	}
+	h := Handle{Mode: modeName(flags), Client: client, Acquired: m.now()}
+	if heartbeat > 0 {
+		h.Heartbeat, h.MaxMissed = heartbeat, cmp.Or(max(maxMissed, 0), defaultMaxMissed)
+		h.renew(h.Acquired)
+	}
+	return m.take(h, flags)
+}
+
+// holdConn takes a handle on a mode bound to the connection c, released by
+// releaseConn when the connection closes.
+func (m *ExecStateManager) holdConn(c *connCodec, mode, client string) (Handle, error) {
+	flags, err := parseMode(mode)
+	if err != nil {
+		return Handle{}, withCode(ErrInvalid, err)
+	}
+	if flags == 0 {
+		return Handle{}, newError(ErrInvalid, "cannot hold the clear mode")
+	}
+	h, err := m.take(Handle{Mode: modeName(flags), Client: client, Conn: c.remote, Acquired: m.now()}, flags)
+	if err != nil {
+		return h, err
+	}
+	c.attach(h.ID)
+	return h, nil
+}
+
+// releaseConn releases the handles still bound to the connection c, which
+// was closed.
+func (m *ExecStateManager) releaseConn(c *connCodec) {
+	for _, id := range c.detach() {
+		h, ok := m.handles.remove(id)
+		if !ok {
+			// released by the client already
+			continue
+		}
+		log.Printf("Connection %s closed, handle %d (%s mode) released", c.remote, h.ID, h.Mode)
+		if err := m.release(handleOwner(id)); err != nil {
+			log.Printf("Hold — failed to apply state: %v", err)
+		}
+	}
+}
+
+// take adds the handle h to the table and holds its flags.
+func (m *ExecStateManager) take(h Handle, flags uint32) (Handle, error) {
+	h = m.handles.add(h)
+	if err := m.hold(handleOwner(h.ID), flags); err != nil {
+		// the caller does not get the handle, do not leak it
+		m.handles.remove(h.ID)
+		m.release(handleOwner(h.ID)) //nolint:errcheck
+		return Handle{}, err
+	}
+	return h, nil
+}
+
+// releaseHandle releases a handle. The state is only downgraded when it was
+// the last handle holding its mode.
+func (m *ExecStateManager) releaseHandle(id uint64) (Handle, error) {
+	h, ok := m.handles.remove(id)
+	if !ok {
+		return h, newError(ErrNotFound, "no handle with ID %d", id)
+	}
+	return h, m.release(handleOwner(id))
+}
+
+// expireLeases drops the leases whose heartbeats stopped.
+func (m *ExecStateManager) expireLeases() {
+	for _, h := range m.handles.removeExpired(m.now()) {
+		log.Printf("Lease — handle %d (%s mode, client %q) missed %d heartbeats, released", h.ID, h.Mode, h.Client, h.MaxMissed)
+		if err := m.release(handleOwner(h.ID)); err != nil {
+			log.Printf("Lease — failed to apply state: %v", err)
+		}
+		m.publish(Event{Type: EventExpire, Detail: handleOwner(h.ID)})
+	}
+}
+
+// runLeases checks the leases periodically until the manager stops.
+func (m *ExecStateManager) runLeases(interval time.Duration) {
+	m.poll(interval, m.expireLeases)
+}
+
+// forceNote describes the handles still held when a force method changes the
+// mode, for the log.
+func (m *ExecStateManager) forceNote() string {
+	counts := m.handles.refcounts()
+	if len(counts) == 0 {
+		return "(force)"
+	}
+	var held []string
+	for _, mode := range slices.Sorted(maps.Keys(counts)) {
+		held = append(held, fmt.Sprintf("%s=%d", mode, counts[mode]))
+	}
+	return "(force, handles still held: " + strings.Join(held, " ") + ")"
+}
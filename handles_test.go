@@ -0,0 +1,214 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRPCAcquireRelease(t *testing.T) {
+	manager, listener, client := setupTestServer(t)
+	defer listener.Close()
+	defer client.Close()
+	defer manager.Stop()
+
+	acquire := func(mode, name string) uint64 {
+		t.Helper()
+		var reply ExecStateReply
+		if err := client.Call("ExecStateManager.Acquire", ExecStateRequest{Mode: mode, Client: name}, &reply); err != nil {
+			t.Fatalf("Acquire RPC call failed: %v", err)
+		}
+		return reply.Handle
+	}
+	release := func(handle uint64) {
+		t.Helper()
+		if err := client.Call("ExecStateManager.Release", ExecStateRequest{Handle: handle}, &ExecStateReply{}); err != nil {
+			t.Fatalf("Release RPC call failed: %v", err)
+		}
+	}
+	expectFlags := func(want uint32) {
+		t.Helper()
+		if got := manager.appliedFlags(); got != want {
+			t.Errorf("expected flags 0x%08X, got 0x%08X", want, got)
+		}
+	}
+
+	first := acquire("display", "backup")
+	second := acquire("display", "render")
+	system := acquire("system", "sync")
+	if first == second || first == 0 {
+		t.Fatalf("expected distinct handles, got %d and %d", first, second)
+	}
+	expectFlags(ES_SYSTEM_REQUIRED | ES_DISPLAY_REQUIRED)
+
+	// a force override does not drop the handles
+	if err := client.Call("ExecStateManager.Clear", ExecStateRequest{}, &ExecStateReply{}); err != nil {
+		t.Fatalf("Clear RPC call failed: %v", err)
+	}
+	expectFlags(ES_SYSTEM_REQUIRED | ES_DISPLAY_REQUIRED)
+
+	var reply ExecStateReply
+	if err := client.Call("ExecStateManager.Read", ExecStateRequest{}, &reply); err != nil {
+		t.Fatalf("Read RPC call failed: %v", err)
+	}
+	if len(reply.Handles) != 3 || reply.Refcounts["display"] != 2 || reply.Refcounts["system"] != 1 {
+		t.Errorf("expected 3 handles, 2 on display, got %+v %v", reply.Handles, reply.Refcounts)
+	}
+
+	// the display stays on until its last handle is released
+	release(first)
+	expectFlags(ES_SYSTEM_REQUIRED | ES_DISPLAY_REQUIRED)
+	release(second)
+	expectFlags(ES_SYSTEM_REQUIRED)
+	release(system)
+	expectFlags(0)
+
+	if err := client.Call("ExecStateManager.Release", ExecStateRequest{Handle: first}, &ExecStateReply{}); err == nil {
+		t.Error("expected an error when releasing a handle twice")
+	}
+	for _, mode := range []string{"clear", "turbo"} {
+		if err := client.Call("ExecStateManager.Acquire", ExecStateRequest{Mode: mode}, &ExecStateReply{}); err == nil {
+			t.Errorf("expected an error when acquiring %s", mode)
+		}
+	}
+}
+
+func TestForceNote(t *testing.T) {
+	manager := &ExecStateManager{}
+	if note := manager.forceNote(); note != "(force)" {
+		t.Errorf("unexpected note %q", note)
+	}
+	manager.handles.add(Handle{Mode: "display"})
+	manager.handles.add(Handle{Mode: "system"})
+	manager.handles.add(Handle{Mode: "display"})
+	if note := manager.forceNote(); note != "(force, handles still held: display=2 system=1)" {
+		t.Errorf("unexpected note %q", note)
+	}
+}
+
+func TestLeases(t *testing.T) {
+	now := time.Date(2026, 3, 4, 21, 0, 0, 0, time.UTC)
+	manager := &ExecStateManager{clock: func() time.Time { return now }}
+	manager.Start()
+	defer manager.Stop()
+
+	lease, err := manager.acquire("system", "agent", 10*time.Second, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease.MaxMissed != defaultMaxMissed || !lease.Expires.Equal(now.Add(30*time.Second)) {
+		t.Fatalf("expected a lease expiring after 3 missed beats, got %+v", lease)
+	}
+	handle, err := manager.acquire("display", "local", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.handles.heartbeat(handle.ID, now); err == nil {
+		t.Error("expected an error for a heartbeat on a handle without heartbeat")
+	}
+
+	// two missed beats, then a heartbeat
+	now = now.Add(25 * time.Second)
+	manager.expireLeases()
+	if _, err := manager.handles.heartbeat(lease.ID, now); err != nil {
+		t.Fatalf("expected the lease to be renewed: %v", err)
+	}
+	now = now.Add(29 * time.Second)
+	manager.expireLeases()
+	if len(manager.handles.list()) != 2 {
+		t.Fatalf("expected the lease to be kept, got %+v", manager.handles.list())
+	}
+
+	// the heartbeats stop
+	cursor := manager.events.cursor()
+	now = now.Add(time.Second)
+	manager.expireLeases()
+	if handles := manager.handles.list(); len(handles) != 1 || handles[0].ID != handle.ID {
+		t.Errorf("expected the lease to expire, got %+v", handles)
+	}
+	events, _ := manager.events.since(cursor)
+	if len(events) != 2 || events[0].Type != EventRelease || events[1].Type != EventExpire || events[1].Detail != handleOwner(lease.ID) {
+		t.Errorf("expected release and expire events, got %v", events)
+	}
+	if _, err := manager.handles.heartbeat(lease.ID, now); err == nil {
+		t.Error("expected an error for a heartbeat on an expired lease")
+	}
+}
+
+func TestRPCHeartbeat(t *testing.T) {
+	manager, listener, client := setupTestServer(t)
+	defer listener.Close()
+	defer client.Close()
+	defer manager.Stop()
+
+	var reply ExecStateReply
+	req := ExecStateRequest{Mode: "system", Client: "agent", Heartbeat: time.Minute, MaxMissed: 2}
+	if err := client.Call("ExecStateManager.Acquire", req, &reply); err != nil {
+		t.Fatalf("Acquire RPC call failed: %v", err)
+	}
+	lease := reply.Handle
+	if len(reply.Handles) != 1 || reply.Handles[0].MaxMissed != 2 {
+		t.Fatalf("expected the lease in the reply, got %+v", reply.Handles)
+	}
+	expires := reply.Handles[0].Expires
+
+	time.Sleep(10 * time.Millisecond)
+	reply = ExecStateReply{}
+	if err := client.Call("ExecStateManager.Heartbeat", ExecStateRequest{Handle: lease}, &reply); err != nil {
+		t.Fatalf("Heartbeat RPC call failed: %v", err)
+	}
+	if len(reply.Handles) != 1 || !reply.Handles[0].Expires.After(expires) {
+		t.Errorf("expected the expiry to move, got %+v", reply.Handles)
+	}
+	if err := client.Call("ExecStateManager.Heartbeat", ExecStateRequest{Handle: lease + 1}, &reply); err == nil {
+		t.Error("expected an error for an unknown lease")
+	}
+}
+
+func TestConnHold(t *testing.T) {
+	manager := &ExecStateManager{}
+	manager.Start()
+	defer manager.Stop()
+	listener, dial := serveTestConns(t, manager)
+	defer listener.Close()
+
+	holder := dial()
+	other := dial()
+	defer other.Close()
+
+	var reply ExecStateReply
+	if err := holder.Call("ExecStateManager.Hold", ExecStateRequest{Mode: "display", Client: "presenter"}, &reply); err != nil {
+		t.Fatalf("Hold RPC call failed: %v", err)
+	}
+	if len(reply.Handles) != 1 || reply.Handles[0].Conn == "" {
+		t.Fatalf("expected a handle bound to the connection, got %+v", reply.Handles)
+	}
+	released := reply.Handle
+	if err := holder.Call("ExecStateManager.Hold", ExecStateRequest{Mode: "system"}, &reply); err != nil {
+		t.Fatalf("Hold RPC call failed: %v", err)
+	}
+	if err := holder.Call("ExecStateManager.Release", ExecStateRequest{Handle: released}, &reply); err != nil {
+		t.Fatalf("Release RPC call failed: %v", err)
+	}
+	if err := holder.Call("ExecStateManager.Hold", ExecStateRequest{Mode: "display"}, &reply); err != nil {
+		t.Fatalf("Hold RPC call failed: %v", err)
+	}
+	if err := other.Call("ExecStateManager.Acquire", ExecStateRequest{Mode: "system"}, &reply); err != nil {
+		t.Fatalf("Acquire RPC call failed: %v", err)
+	}
+	if flags := manager.appliedFlags(); flags != ES_SYSTEM_REQUIRED|ES_DISPLAY_REQUIRED {
+		t.Fatalf("expected the display mode, got %#x", flags)
+	}
+
+	// the holder goes away without releasing its handles
+	holder.Close()
+	waitFor(t, "the connection handles to be released", func() bool {
+		return len(manager.handles.list()) == 1
+	})
+	if flags := manager.appliedFlags(); flags != ES_SYSTEM_REQUIRED {
+		t.Errorf("expected the system mode of the other client, got %#x", flags)
+	}
+
+	if err := manager.Hold(ExecStateRequest{Mode: "system"}, &reply); err == nil {
+		t.Error("expected an error for a hold without connection")
+	}
+}
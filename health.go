@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Number of consecutive backend failures after which the server is degraded.
+const maxBackendFailures = 3
+
+// Health statuses
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+	HealthStopped  = "stopped"
+)
+
+// Health reports whether the server is functional. The server is degraded
+// after maxBackendFailures consecutive failed calls to the backend, and ready
+// when it is healthy and accepting RPC connections.
+type Health struct {
+	Status        string        `json:"status"`
+	Ready         bool          `json:"ready"`
+	Listening     bool          `json:"listening"`
+	Failures      int           `json:"failures"` // consecutive backend failures
+	LastError     string        `json:"lastError,omitempty"`
+	LastErrorTime time.Time     `json:"lastErrorTime,omitzero"`
+	LastSuccess   time.Time     `json:"lastSuccess,omitzero"`
+	Reassertions  uint64        `json:"reassertions"`
+	Drifts        uint64        `json:"drifts"` // times the backend was not in the state last applied
+	LastDrift     time.Time     `json:"lastDrift,omitzero"`
+	Started       time.Time     `json:"started"`
+	Uptime        time.Duration `json:"uptime"`
+}
+
+// backendStatus tracks the outcome of the calls to the backend, and the state
+// it should be in.
+type backendStatus struct {
+	mu            sync.Mutex
+	failures      int // consecutive failures
+	lastError     string
+	lastErrorTime time.Time
+	lastSuccess   time.Time
+	calls         uint64
+	failuresTotal uint64
+	reassertions  uint64
+	drifts        uint64
+	lastDrift     time.Time
+	applied       uint32 // flags last applied successfully
+	known         bool   // whether the backend should be in the applied state
+}
+
+// record accounts for a call to the backend with flags, which returned the
+// previous state ret or failed with err, and reports whether the previous
+// state differed from the one expected.
+func (b *backendStatus) record(flags, ret uint32, reassert bool, err error, now time.Time) (expected uint32, drifted bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.calls++
+	if reassert {
+		b.reassertions++
+	}
+	if err != nil {
+		b.failures++
+		b.failuresTotal++
+		b.lastError = err.Error()
+		b.lastErrorTime = now
+		b.known = false
+		if b.failures == maxBackendFailures {
+			log.Printf("Health — backend failed %d times in a row, server degraded", b.failures)
+		}
+		return 0, false
+	}
+
+	if b.failures >= maxBackendFailures {
+		log.Printf("Health — backend recovered after %d failures", b.failures)
+	}
+	b.failures = 0
+	b.lastSuccess = now
+	expected, drifted = b.applied, b.known && ret != b.applied
+	if drifted {
+		b.drifts++
+		b.lastDrift = now
+	}
+	b.applied, b.known = flags, true
+	return expected, drifted
+}
+
+// appliedFlags returns the flags last applied successfully, if any.
+func (b *backendStatus) appliedFlags() (uint32, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.applied, !b.lastSuccess.IsZero()
+}
+
+// health returns the current health of the manager.
+func (m *ExecStateManager) health() *Health {
+	now := m.now()
+	h := &Health{
+		Status:    HealthOK,
+		Listening: m.listening.Load(),
+		Started:   m.started,
+		Uptime:    now.Sub(m.started).Round(time.Second),
+	}
+
+	m.backend.mu.Lock()
+	h.Failures = m.backend.failures
+	h.LastError = m.backend.lastError
+	h.LastErrorTime = m.backend.lastErrorTime
+	h.LastSuccess = m.backend.lastSuccess
+	h.Reassertions = m.backend.reassertions
+	h.Drifts = m.backend.drifts
+	h.LastDrift = m.backend.lastDrift
+	m.backend.mu.Unlock()
+
+	select {
+	case <-m.mgrShutdownCh:
+		h.Status = HealthStopped
+	default:
+		if h.Failures >= maxBackendFailures {
+			h.Status = HealthDegraded
+		}
+	}
+	h.Ready = h.Status == HealthOK && h.Listening
+	return h
+}
+
+// serveHealth reports the health as JSON, with status 503 if the server is not healthy.
+func (m *ExecStateManager) serveHealth(w http.ResponseWriter, r *http.Request) {
+	h := m.health()
+	writeHealth(w, h, h.Status == HealthOK)
+}
+
+// serveReady reports the health as JSON, with status 503 if the server is not ready.
+func (m *ExecStateManager) serveReady(w http.ResponseWriter, r *http.Request) {
+	h := m.health()
+	writeHealth(w, h, h.Ready)
+}
+
+func writeHealth(w http.ResponseWriter, h *Health, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(h) //nolint:errcheck
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	var failing atomic.Bool
+	manager := &ExecStateManager{
+		setState: func(flags uint32) (uint32, error) {
+			if failing.Load() {
+				return 0, errors.New("access denied")
+			}
+			return ES_CONTINUOUS, nil
+		},
+	}
+	manager.Start()
+	manager.listening.Store(true)
+
+	server := httptest.NewServer(manager.httpHandler())
+	defer server.Close()
+
+	get := func(path string) (int, Health) {
+		t.Helper()
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var h Health
+		if err := json.NewDecoder(resp.Body).Decode(&h); err != nil {
+			t.Fatalf("%s: invalid body: %v", path, err)
+		}
+		return resp.StatusCode, h
+	}
+
+	if err := manager.System(ExecStateRequest{}, &ExecStateReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if code, h := get("/readyz"); code != http.StatusOK || h.Status != HealthOK || !h.Ready || h.LastSuccess.IsZero() {
+		t.Errorf("expected ready, got %d %+v", code, h)
+	}
+
+	// the server is degraded after repeated failures
+	failing.Store(true)
+	for i := 0; i < maxBackendFailures; i++ {
+		if code, _ := get("/healthz"); code != http.StatusOK {
+			t.Fatalf("expected healthy after %d failures, got %d", i, code)
+		}
+		if err := manager.Display(ExecStateRequest{}, &ExecStateReply{}); err == nil {
+			t.Fatal("expected the backend to fail")
+		}
+	}
+	code, h := get("/healthz")
+	if code != http.StatusServiceUnavailable || h.Status != HealthDegraded || h.Failures != maxBackendFailures || h.LastError != "access denied" {
+		t.Errorf("expected degraded, got %d %+v", code, h)
+	}
+	if code, h := get("/readyz"); code != http.StatusServiceUnavailable || h.Ready {
+		t.Errorf("expected not ready, got %d %+v", code, h)
+	}
+
+	// and recovers with the next successful call
+	failing.Store(false)
+	if err := manager.Display(ExecStateRequest{}, &ExecStateReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if code, h := get("/healthz"); code != http.StatusOK || h.Failures != 0 || h.LastError == "" {
+		t.Errorf("expected healthy with the last error kept, got %d %+v", code, h)
+	}
+
+	manager.listening.Store(false)
+	if code, h := get("/readyz"); code != http.StatusServiceUnavailable || h.Status != HealthOK {
+		t.Errorf("expected healthy but not ready without listener, got %d %+v", code, h)
+	}
+
+	manager.Stop()
+	if code, h := get("/healthz"); code != http.StatusServiceUnavailable || h.Status != HealthStopped {
+		t.Errorf("expected stopped, got %d %+v", code, h)
+	}
+}
+
+func TestRPCPing(t *testing.T) {
+	manager, listener, client := setupTestServer(t)
+	defer listener.Close()
+	defer client.Close()
+	defer manager.Stop()
+
+	var reply ExecStateReply
+	if err := client.Call("ExecStateManager.Ping", ExecStateRequest{}, &reply); err != nil {
+		t.Fatalf("Ping RPC call failed: %v", err)
+	}
+	if h := reply.Health; h == nil || h.Status != HealthOK || !h.Ready || !h.Listening || h.Started.IsZero() {
+		t.Errorf("expected a healthy and ready server, got %+v", h)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Number of intervals kept in memory (and loaded from the history file).
+const maxHistory = 4096
+
+// Interval is a period during which the applied mode and its holders did not
+// change. End is zero while the interval is still open.
+type Interval struct {
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Flags   uint32    `json:"flags"`
+	Mode    string    `json:"mode"`
+	Holders []string  `json:"holders,omitempty"` // registered processes and hold owners
+}
+
+// history records the mode intervals from the events published by the
+// manager, and appends them to a file as they end if one was opened.
+type history struct {
+	mu        sync.Mutex
+	intervals []Interval // closed intervals, oldest first
+	current   Interval
+	flags     uint32
+	holds     map[string]bool
+	processes map[int]string // name of the registered processes
+	file      *os.File
+}
+
+// open loads the intervals of a history file and appends the next ones to it.
+func (h *history) open(path string) error {
+	intervals, err := readHistory(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.intervals = append(intervals, h.intervals...)
+	h.trimLocked()
+	h.file = file
+	return nil
+}
+
+func (h *history) close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.file == nil {
+		return nil
+	}
+	return h.file.Close()
+}
+
+// readHistory reads the last intervals of a history file.
+func readHistory(path string) ([]Interval, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() //nolint:errcheck
+
+	var intervals []Interval
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var i Interval
+		if err := json.Unmarshal(scanner.Bytes(), &i); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		intervals = append(intervals, i)
+		if len(intervals) > 2*maxHistory {
+			intervals = slices.Delete(intervals, 0, len(intervals)-maxHistory)
+		}
+	}
+	if len(intervals) > maxHistory {
+		intervals = intervals[len(intervals)-maxHistory:]
+	}
+	return intervals, scanner.Err()
+}
+
+// observe updates the state from an event, and starts a new interval if the
+// mode or its holders changed. The shutdown event ends the last interval.
+func (h *history) observe(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.holds == nil {
+		h.holds = make(map[string]bool)
+		h.processes = make(map[int]string)
+	}
+	switch e.Type {
+	case EventMode:
+		h.flags = e.Flags
+	case EventRegister:
+		h.processes[e.Process] = e.Detail
+	case EventUnregister:
+		delete(h.processes, e.Process)
+	case EventHold:
+		h.holds[e.Detail] = true
+	case EventRelease:
+		delete(h.holds, e.Detail)
+	case EventShutdown:
+		h.endLocked(e.Time)
+		return
+	default:
+		return
+	}
+
+	holders := h.holdersLocked()
+	if !h.current.Start.IsZero() && h.current.Flags == h.flags && slices.Equal(h.current.Holders, holders) {
+		return
+	}
+	h.endLocked(e.Time)
+	h.current = Interval{Start: e.Time, Flags: h.flags, Mode: modeName(h.flags), Holders: holders}
+}
+
+// holdersLocked returns the sorted names of the registered processes and hold owners.
+func (h *history) holdersLocked() []string {
+	var holders []string
+	for pid, name := range h.processes {
+		if name == "" {
+			name = "pid " + strconv.Itoa(pid)
+		}
+		holders = append(holders, name)
+	}
+	for owner := range h.holds {
+		holders = append(holders, owner)
+	}
+	slices.Sort(holders)
+	return slices.Compact(holders)
+}
+
+// endLocked closes the current interval, if any, and writes it to the file.
+func (h *history) endLocked(end time.Time) {
+	if h.current.Start.IsZero() {
+		return
+	}
+	h.current.End = end
+	if end.After(h.current.Start) {
+		h.intervals = append(h.intervals, h.current)
+		h.trimLocked()
+		if h.file != nil {
+			h.writeLocked(h.current)
+		}
+	}
+	h.current = Interval{}
+}
+
+func (h *history) writeLocked(i Interval) {
+	data, err := json.Marshal(i)
+	if err != nil {
+		log.Printf("History encoding error: %v", err)
+		return
+	}
+	if _, err := h.file.Write(append(data, '\n')); err != nil {
+		log.Printf("History write error: %v", err)
+	}
+}
+
+func (h *history) trimLocked() {
+	if len(h.intervals) > maxHistory {
+		h.intervals = slices.Delete(h.intervals, 0, len(h.intervals)-maxHistory)
+	}
+}
+
+// list returns the intervals, the current one ending at now.
+func (h *history) list(now time.Time) []Interval {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	intervals := slices.Clone(h.intervals)
+	if !h.current.Start.IsZero() {
+		current := h.current
+		current.End = now
+		intervals = append(intervals, current)
+	}
+	return intervals
+}
+
+// UsageTotal is the time spent in a mode, or held awake by a registrant.
+type UsageTotal struct {
+	Name     string
+	Duration time.Duration
+}
+
+// UsageReport sums up the history between Since and Until. Registrants are
+// only counted while the computer was held awake (mode other than clear).
+type UsageReport struct {
+	Since       time.Time
+	Until       time.Time
+	Modes       []UsageTotal
+	Registrants []UsageTotal
+}
+
+// buildReport sums up the intervals within [since, until). Zero values do
+// not limit the period.
+func buildReport(intervals []Interval, since, until time.Time) *UsageReport {
+	report := &UsageReport{Since: since, Until: until}
+	modes := make(map[string]time.Duration)
+	registrants := make(map[string]time.Duration)
+	for _, i := range intervals {
+		start, end := i.Start, i.End
+		if !since.IsZero() && start.Before(since) {
+			start = since
+		}
+		if !until.IsZero() && end.After(until) {
+			end = until
+		}
+		if !end.After(start) {
+			continue
+		}
+		if report.Since.IsZero() || start.Before(report.Since) {
+			report.Since = start
+		}
+		if report.Until.IsZero() || end.After(report.Until) {
+			report.Until = end
+		}
+
+		modes[i.Mode] += end.Sub(start)
+		if i.Flags != 0 {
+			for _, holder := range i.Holders {
+				registrants[holder] += end.Sub(start)
+			}
+		}
+	}
+	report.Modes = sortTotals(modes)
+	report.Registrants = sortTotals(registrants)
+	return report
+}
+
+// sortTotals returns the totals by decreasing duration.
+func sortTotals(totals map[string]time.Duration) []UsageTotal {
+	var list []UsageTotal
+	for name, d := range totals {
+		list = append(list, UsageTotal{Name: name, Duration: d})
+	}
+	slices.SortFunc(list, func(a, b UsageTotal) int {
+		return cmp.Or(cmp.Compare(b.Duration, a.Duration), strings.Compare(a.Name, b.Name))
+	})
+	return list
+}
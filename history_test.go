@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	base := time.Date(2026, 3, 2, 8, 0, 0, 0, time.UTC)
+	at := func(h float64) time.Time { return base.Add(time.Duration(h * float64(time.Hour))) }
+
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	var h history
+	if err := h.open(path); err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	h.observe(Event{Time: at(0), Type: EventMode, Flags: ES_SYSTEM_REQUIRED})
+	h.observe(Event{Time: at(1), Type: EventRegister, Process: 42, Detail: "backup"})
+	h.observe(Event{Time: at(1), Type: EventMode, Flags: ES_SYSTEM_REQUIRED | ES_DISPLAY_REQUIRED})
+	h.observe(Event{Time: at(3), Type: EventHold, Flags: ES_SYSTEM_REQUIRED, Detail: "activity"})
+	h.observe(Event{Time: at(3), Type: EventHold, Flags: ES_SYSTEM_REQUIRED, Detail: "activity"}) // no change
+	h.observe(Event{Time: at(4), Type: EventUnregister, Process: 42})
+	h.observe(Event{Time: at(4), Type: EventMode, Flags: ES_SYSTEM_REQUIRED})
+	h.observe(Event{Time: at(5), Type: EventRelease, Detail: "activity"})
+	h.observe(Event{Time: at(5), Type: EventMode, Flags: 0})
+	h.observe(Event{Time: at(6), Type: EventRegister, Process: 7}) // registered while clear
+
+	intervals := h.list(at(8))
+	want := []struct {
+		mode    string
+		hours   float64
+		holders string
+	}{
+		{"system", 1, ""},
+		{"display", 2, "backup"},
+		{"display", 1, "activity,backup"},
+		{"system", 1, "activity"},
+		{"clear", 1, ""},
+		{"clear", 2, "pid 7"},
+	}
+	if len(intervals) != len(want) {
+		t.Fatalf("expected %d intervals, got %+v", len(want), intervals)
+	}
+	for i, w := range want {
+		got := intervals[i]
+		if got.Mode != w.mode || got.End.Sub(got.Start).Hours() != w.hours || strings.Join(got.Holders, ",") != w.holders {
+			t.Errorf("interval %d: expected %s for %vh held by %q, got %+v", i, w.mode, w.hours, w.holders, got)
+		}
+	}
+
+	report := buildReport(intervals, at(0.5), time.Time{})
+	if !report.Since.Equal(at(0.5)) || !report.Until.Equal(at(8)) {
+		t.Errorf("expected the report from 0.5h to 8h, got %v to %v", report.Since, report.Until)
+	}
+	wantModes := "clear=3h0m0s display=3h0m0s system=1h30m0s"
+	if got := totalsString(report.Modes); got != wantModes {
+		t.Errorf("expected modes %s, got %s", wantModes, got)
+	}
+	wantRegistrants := "backup=3h0m0s activity=2h0m0s"
+	if got := totalsString(report.Registrants); got != wantRegistrants {
+		t.Errorf("expected registrants %s, got %s", wantRegistrants, got)
+	}
+
+	// the closed intervals are persisted, and the last one ends at shutdown
+	h.observe(Event{Time: at(9), Type: EventShutdown})
+	if err := h.close(); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	var reloaded history
+	if err := reloaded.open(path); err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	defer reloaded.close()
+	if intervals := reloaded.list(at(10)); len(intervals) != len(want) || !intervals[len(want)-1].End.Equal(at(9)) {
+		t.Errorf("expected %d intervals ending at 9h, got %+v", len(want), intervals)
+	}
+}
+
+func totalsString(totals []UsageTotal) string {
+	var parts []string
+	for _, t := range totals {
+		parts = append(parts, t.Name+"="+t.Duration.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestWriteReport(t *testing.T) {
+	report := &UsageReport{
+		Modes:       []UsageTotal{{Name: "system", Duration: 90 * time.Minute}},
+		Registrants: []UsageTotal{{Name: "backup", Duration: time.Hour}},
+	}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"csv", "kind,name,seconds,hours\nmode,system,5400,1.50\nregistrant,backup,3600,1.00\n"},
+		{"json", `"kind": "registrant",`},
+		{"table", "registrant  backup  1h0m0s    1.00"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := writeReport(&buf, report, tt.format); err != nil {
+			t.Fatalf("writeReport(%s) failed: %v", tt.format, err)
+		}
+		if !strings.Contains(buf.String(), tt.want) {
+			t.Errorf("writeReport(%s) = %q, expected to contain %q", tt.format, buf.String(), tt.want)
+		}
+	}
+	if err := writeReport(&bytes.Buffer{}, report, "xml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestRPCReport(t *testing.T) {
+	manager, listener, client := setupTestServer(t)
+	defer listener.Close()
+	defer client.Close()
+	defer manager.Stop()
+
+	if err := client.Call("ExecStateManager.System", ExecStateRequest{}, &ExecStateReply{}); err != nil {
+		t.Fatalf("System RPC call failed: %v", err)
+	}
+	if err := client.Call("ExecStateManager.Register", ExecStateRequest{Process: 42, Client: "backup"}, &ExecStateReply{}); err != nil {
+		t.Fatalf("Register RPC call failed: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	var reply ExecStateReply
+	if err := client.Call("ExecStateManager.Report", ExecStateRequest{}, &reply); err != nil {
+		t.Fatalf("Report RPC call failed: %v", err)
+	}
+	if reply.Report == nil || len(reply.Report.Modes) != 1 || reply.Report.Modes[0].Name != "system" {
+		t.Fatalf("expected a report of system mode, got %+v", reply.Report)
+	}
+	if len(reply.Report.Registrants) != 1 || reply.Report.Registrants[0].Name != "backup" || reply.Report.Registrants[0].Duration <= 0 {
+		t.Errorf("expected backup as registrant, got %+v", reply.Report.Registrants)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import "log"
+
+// Holds keep the computer awake on top of the mode set via RPC or the schedule,
+// so that eg. clearing the mode does not let the computer sleep while a condition
+// is still met. Each hold is identified by its owner.
+
+// hold keeps flags in effect on behalf of owner until release(owner) is called.
+// Holding again replaces the flags previously held by the same owner.
+func (m *ExecStateManager) hold(owner string, flags uint32) error {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+
+	before := m.effectiveLocked()
+	if held, ok := m.holds[owner]; ok && held == flags {
+		return nil
+	}
+	m.holds[owner] = flags
+	log.Printf("Hold — %s holds %s mode", owner, modeName(flags))
+	m.publish(Event{Type: EventHold, Flags: flags, Detail: owner})
+	if after := m.effectiveLocked(); after != before {
+		return m.applyLocked(after, &ExecStateReply{})
+	}
+	return nil
+}
+
+// release drops the hold of owner, if any.
+func (m *ExecStateManager) release(owner string) error {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+
+	if _, ok := m.holds[owner]; !ok {
+		return nil
+	}
+	before := m.effectiveLocked()
+	delete(m.holds, owner)
+	log.Printf("Hold — %s released", owner)
+	m.publish(Event{Type: EventRelease, Detail: owner})
+	if after := m.effectiveLocked(); after != before {
+		return m.applyLocked(after, &ExecStateReply{})
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// httpHandler serves the HTTP endpoints of the manager:
+//
+//	/events   Server-Sent Events stream of state changes
+//	/healthz  health of the server, 503 when degraded or stopped
+//	/readyz   readiness of the server, 503 when not healthy or not listening
+//	/metrics  counters in the Prometheus text format
+//
+// and the web admin page with --web, see registerWeb.
+func (m *ExecStateManager) httpHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /events", m.serveEvents)
+	mux.HandleFunc("GET /healthz", m.serveHealth)
+	mux.HandleFunc("GET /readyz", m.serveReady)
+	mux.HandleFunc("GET /metrics", m.serveMetrics)
+	if m.web {
+		m.registerWeb(mux)
+	}
+	return mux
+}
+
+// serveEvents streams events as Server-Sent Events. A client reconnecting with
+// the Last-Event-ID header (or the cursor query parameter) resumes where it left.
+func (m *ExecStateManager) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	cursorParam := r.Header.Get("Last-Event-ID")
+	if cursorParam == "" {
+		cursorParam = r.URL.Query().Get("cursor")
+	}
+	var cursor uint64
+	if cursorParam != "" {
+		var err error
+		if cursor, err = strconv.ParseUint(cursorParam, 10, 64); err != nil {
+			httpError(w, newError(ErrInvalid, "invalid cursor %q", cursorParam))
+			return
+		}
+	} else {
+		// new subscribers only get the events to come
+		cursor = m.events.cursor()
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		events, changed := m.events.since(cursor)
+		for _, e := range events {
+			data, err := json.Marshal(e)
+			if err != nil {
+				log.Printf("event encoding error: %v", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Seq, e.Type, data); err != nil {
+				return
+			}
+			cursor = e.Seq
+			if e.Type == EventShutdown {
+				flusher.Flush()
+				return
+			}
+		}
+		flusher.Flush()
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		case <-m.mgrShutdownCh:
+			// deliver the shutdown event before closing the stream
+			if events, _ := m.events.since(cursor); len(events) == 0 {
+				return
+			}
+		}
+	}
+}
+
+// httpError replies with the status matching the code of err, and a reply
+// with the code and message of err.
+func httpError(w http.ResponseWriter, err error) {
+	code := ErrorCodeOf(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code.HTTPStatus())
+	json.NewEncoder(w).Encode(&ExecStateReply{Code: code, Error: err.Error()}) //nolint:errcheck
+}
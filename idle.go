@@ -0,0 +1,61 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// isIdle reports whether nothing holds the server: no registered process, no
+// hold (conditions met or in their cool-down period), no schedule window open
+// and no mode forced by a client. The initial mode of the server does not
+// count, or the server would never be idle.
+func (m *ExecStateManager) isIdle() bool {
+	if m.hasRegisteredProcesses() || m.schedule.active(m.now()) {
+		return false
+	}
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+
+	return len(m.holds) == 0 && !m.forced
+}
+
+// runIdleTimeout shuts the server down once it has been idle for timeout,
+// until the manager stops. Idleness is checked again on every state change.
+func (m *ExecStateManager) runIdleTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	var idleSince time.Time
+	cursor := m.events.cursor()
+	for {
+		events, changed := m.events.since(cursor)
+		if len(events) > 0 {
+			cursor = events[len(events)-1].Seq
+		}
+
+		var expired <-chan time.Time
+		if !m.isIdle() {
+			idleSince = time.Time{}
+		} else {
+			if idleSince.IsZero() {
+				idleSince = m.now()
+			}
+			remaining := timeout - m.now().Sub(idleSince)
+			if remaining <= 0 {
+				log.Printf("Idle for %v, shutting down server", timeout)
+				if err := m.Shutdown(ExecStateRequest{Client: "idle-timeout"}, &ExecStateReply{}); err != nil {
+					log.Printf("Idle shutdown error: %v", err)
+				}
+				return
+			}
+			expired = time.After(remaining)
+		}
+
+		select {
+		case <-changed:
+		case <-expired:
+		case <-m.mgrShutdownCh:
+			return
+		}
+	}
+}
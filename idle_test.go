@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestIdleTimeout(t *testing.T) {
+	manager, listener, client := setupTestServer(t)
+	defer client.Close()
+	defer manager.Stop()
+	closed := make(chan struct{})
+	manager.listener = closeNotifier{Listener: listener, closed: closed}
+	manager.keepOnEmpty = true
+
+	if err := client.Call("ExecStateManager.Register", ExecStateRequest{Process: 42}, &ExecStateReply{}); err != nil {
+		t.Fatalf("Register RPC call failed: %v", err)
+	}
+	go manager.runIdleTimeout(50 * time.Millisecond)
+
+	// held by a registered process, then by a condition
+	time.Sleep(100 * time.Millisecond)
+	if err := manager.hold("activity", ES_SYSTEM_REQUIRED); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Call("ExecStateManager.Unregister", ExecStateRequest{Process: 42}, &ExecStateReply{}); err != nil {
+		t.Fatalf("Unregister RPC call failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-closed:
+		t.Fatal("expected the server to keep running while held")
+	default:
+	}
+
+	start := time.Now()
+	if err := manager.release("activity"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-closed:
+		if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+			t.Errorf("expected the server to shut down after the idle timeout, took %v", elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the server to shut down when idle")
+	}
+}
+
+func TestIdleTimeoutModes(t *testing.T) {
+	manager, listener, client := setupTestServer(t)
+	defer client.Close()
+	defer manager.Stop()
+	closed := make(chan struct{})
+	manager.listener = closeNotifier{Listener: listener, closed: closed}
+
+	// a window spanning every whole day is open
+	rule, err := parseScheduleRule("mon-sun 00:00-00:00 system")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule = manager.schedule.add(rule)
+	if err := manager.evaluateSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	go manager.runIdleTimeout(50 * time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+
+	// then a mode set by a client
+	manager.schedule.remove(rule.ID)
+	if err := client.Call("ExecStateManager.Display", ExecStateRequest{}, &ExecStateReply{}); err != nil {
+		t.Fatalf("Display RPC call failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-closed:
+		t.Fatal("expected the server to keep running in a schedule window or a forced mode")
+	default:
+	}
+
+	if err := client.Call("ExecStateManager.Clear", ExecStateRequest{}, &ExecStateReply{}); err != nil {
+		t.Fatalf("Clear RPC call failed: %v", err)
+	}
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the server to shut down once the mode is cleared")
+	}
+}
+
+func TestExitOnEmpty(t *testing.T) {
+	for _, keepOnEmpty := range []bool{false, true} {
+		manager, listener, client := setupTestServer(t)
+		closed := make(chan struct{})
+		manager.listener = closeNotifier{Listener: listener, closed: closed}
+		manager.keepOnEmpty = keepOnEmpty
+
+		if err := client.Call("ExecStateManager.Register", ExecStateRequest{Process: 42}, &ExecStateReply{}); err != nil {
+			t.Fatalf("Register RPC call failed: %v", err)
+		}
+		if err := client.Call("ExecStateManager.Unregister", ExecStateRequest{Process: 42}, &ExecStateReply{}); err != nil {
+			t.Fatalf("Unregister RPC call failed: %v", err)
+		}
+		select {
+		case <-closed:
+			if keepOnEmpty {
+				t.Error("expected the server to keep running with --exit-on-empty=false")
+			}
+		default:
+			if !keepOnEmpty {
+				t.Error("expected the server to shut down when the last process unregisters")
+			}
+		}
+		client.Close()
+		listener.Close()
+		manager.Stop()
+	}
+}
+
+// closeNotifier closes a channel when the listener is closed.
+type closeNotifier struct {
+	net.Listener
+	closed chan struct{}
+}
+
+func (c closeNotifier) Close() error {
+	close(c.closed)
+	return c.Listener.Close()
+}
@@ -0,0 +1,88 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// inhibitCommand returns the command holding the inhibitor locks of the given
+// types until its standard input is closed. Tests replace it.
+var inhibitCommand = func(what string) *exec.Cmd {
+	return exec.Command("systemd-inhibit", "--what="+what, "--who="+name,
+		"--why=Keeping the computer awake", "--mode=block", "cat")
+}
+
+// inhibitor emulates the execution state with systemd inhibitor locks, which
+// are released when the systemd-inhibit process holding them exits. Its child
+// exits when its standard input is closed, which also happens if the server dies.
+var inhibitor struct {
+	mu     sync.Mutex
+	flags  uint32
+	what   string
+	cmd    *exec.Cmd
+	stdin  io.Closer     // closing it ends cmd
+	exited chan struct{} // closed when cmd exits
+}
+
+// inhibitWhat returns the inhibitor lock types matching the execution state flags:
+// sleep for the system (and away mode), idle for the display.
+func inhibitWhat(flags uint32) string {
+	var what []string
+	if flags&(ES_SYSTEM_REQUIRED|ES_AWAYMODE_REQUIRED|ES_DISPLAY_REQUIRED) != 0 {
+		what = append(what, "sleep")
+	}
+	if flags&ES_DISPLAY_REQUIRED != 0 {
+		what = append(what, "idle")
+	}
+	return strings.Join(what, ":")
+}
+
+// SetThreadExecutionState takes the systemd inhibitor locks matching flags,
+// and returns the previous state like the Windows API. If the locks were
+// released behind our back, the previous state has no flag but ES_CONTINUOUS.
+func SetThreadExecutionState(flags uint32) (uint32, error) {
+	inhibitor.mu.Lock()
+	defer inhibitor.mu.Unlock()
+
+	previous := inhibitor.flags | ES_CONTINUOUS
+	if inhibitor.cmd != nil {
+		select {
+		case <-inhibitor.exited:
+			previous = ES_CONTINUOUS
+			inhibitor.cmd = nil
+		default:
+		}
+	}
+
+	what := inhibitWhat(flags)
+	if inhibitor.cmd != nil && what != inhibitor.what {
+		inhibitor.stdin.Close() //nolint:errcheck
+		<-inhibitor.exited
+		inhibitor.cmd = nil
+	}
+	if inhibitor.cmd == nil && what != "" {
+		cmd := inhibitCommand(what)
+		stdin, err := cmd.StdinPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err != nil {
+			inhibitor.flags, inhibitor.what = 0, ""
+			return 0, fmt.Errorf("systemd-inhibit: %w", err)
+		}
+		exited := make(chan struct{})
+		go func() {
+			cmd.Wait() //nolint:errcheck
+			close(exited)
+		}()
+		inhibitor.cmd, inhibitor.stdin, inhibitor.exited = cmd, stdin, exited
+	}
+	inhibitor.what = what
+	inhibitor.flags = flags
+	return previous, nil
+}
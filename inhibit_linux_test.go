@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os/exec"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// inhibitCalls records the lock types requested from the fake systemd-inhibit.
+var inhibitCalls struct {
+	sync.Mutex
+	what []string
+	cmds []*exec.Cmd
+}
+
+func init() {
+	// tests do not take real inhibitor locks
+	inhibitCommand = func(what string) *exec.Cmd {
+		cmd := exec.Command("cat")
+		inhibitCalls.Lock()
+		inhibitCalls.what = append(inhibitCalls.what, what)
+		inhibitCalls.cmds = append(inhibitCalls.cmds, cmd)
+		inhibitCalls.Unlock()
+		return cmd
+	}
+}
+
+func TestInhibitWhat(t *testing.T) {
+	tests := map[uint32]string{
+		0:                  "",
+		ES_SYSTEM_REQUIRED: "sleep",
+		ES_SYSTEM_REQUIRED | ES_AWAYMODE_REQUIRED: "sleep",
+		ES_SYSTEM_REQUIRED | ES_DISPLAY_REQUIRED:  "sleep:idle",
+	}
+	for flags, want := range tests {
+		if got := inhibitWhat(flags | ES_CONTINUOUS); got != want {
+			t.Errorf("inhibitWhat(0x%08X) = %q, want %q", flags, got, want)
+		}
+	}
+}
+
+func TestSetThreadExecutionState(t *testing.T) {
+	inhibitCalls.Lock()
+	start := len(inhibitCalls.what)
+	inhibitCalls.Unlock()
+
+	steps := []struct {
+		flags    uint32
+		previous uint32
+	}{
+		{ES_CONTINUOUS | ES_SYSTEM_REQUIRED, 0},
+		{ES_CONTINUOUS | ES_SYSTEM_REQUIRED | ES_AWAYMODE_REQUIRED, ES_CONTINUOUS | ES_SYSTEM_REQUIRED},
+		{ES_CONTINUOUS | ES_SYSTEM_REQUIRED | ES_DISPLAY_REQUIRED, ES_CONTINUOUS | ES_SYSTEM_REQUIRED | ES_AWAYMODE_REQUIRED},
+	}
+	for i, step := range steps {
+		previous, err := SetThreadExecutionState(step.flags)
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if i > 0 && previous != step.previous {
+			t.Errorf("step %d: previous state 0x%08X, want 0x%08X", i, previous, step.previous)
+		}
+	}
+
+	// the locks were taken twice, away mode needs no other lock than system
+	inhibitCalls.Lock()
+	what := slices.Clone(inhibitCalls.what[start:])
+	last := inhibitCalls.cmds[len(inhibitCalls.cmds)-1]
+	inhibitCalls.Unlock()
+	if !slices.Equal(what, []string{"sleep", "sleep:idle"}) {
+		t.Errorf("expected sleep then sleep:idle locks, got %v", what)
+	}
+
+	// the locks are released behind our back
+	last.Process.Kill() //nolint:errcheck
+	waitFor(t, "the inhibitor to exit", func() bool {
+		inhibitor.mu.Lock()
+		defer inhibitor.mu.Unlock()
+		select {
+		case <-inhibitor.exited:
+			return true
+		default:
+			return false
+		}
+	})
+	if previous, err := SetThreadExecutionState(ES_CONTINUOUS | ES_SYSTEM_REQUIRED | ES_DISPLAY_REQUIRED); err != nil || previous != ES_CONTINUOUS {
+		t.Errorf("expected the released locks to be reported, got 0x%08X %v", previous, err)
+	}
+
+	if _, err := SetThreadExecutionState(ES_CONTINUOUS); err != nil {
+		t.Fatal(err)
+	}
+	inhibitor.mu.Lock()
+	defer inhibitor.mu.Unlock()
+	if inhibitor.cmd != nil {
+		t.Error("expected the locks to be released when the state is cleared")
+	}
+}
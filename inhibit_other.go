@@ -0,0 +1,13 @@
+//go:build !windows && !linux
+
+package main
+
+import (
+	"errors"
+	"runtime"
+)
+
+// SetThreadExecutionState is not available on this platform. Tests replace it.
+var SetThreadExecutionState = func(flags uint32) (uint32, error) {
+	return 0, errors.New("execution state is not supported on " + runtime.GOOS)
+}
@@ -0,0 +1,22 @@
+//go:build !windows && !linux
+
+package main
+
+import "sync"
+
+// fakeExecutionState records the flags like the Windows API, which this
+// platform does not have.
+var fakeExecutionState struct {
+	sync.Mutex
+	flags uint32
+}
+
+func init() {
+	SetThreadExecutionState = func(flags uint32) (uint32, error) {
+		fakeExecutionState.Lock()
+		defer fakeExecutionState.Unlock()
+		previous := fakeExecutionState.flags | ES_CONTINUOUS
+		fakeExecutionState.flags = flags
+		return previous, nil
+	}
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/rpc"
+	"net/url"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long a client has to send its token, and the longest line accepted.
+const (
+	tokenTimeout  = 10 * time.Second
+	maxTokenLine  = 1024
+	tokenAccepted = "OK"
+	tokenDenied   = "DENIED"
+)
+
+// parseListen parses a --listen value like "unix:/run/nosleep.sock",
+// "tcp:127.0.0.1:9001" or "tcp6:[::1]:9001" into a network and an address.
+func parseListen(s string) (network, address string, err error) {
+	network, address, ok := strings.Cut(s, ":")
+	if !ok || address == "" {
+		return "", "", fmt.Errorf("invalid listen address %q (expected NETWORK:ADDRESS)", s)
+	}
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix", "unixpacket":
+		return network, address, nil
+	}
+	return "", "", fmt.Errorf("invalid network %q in listen address %q (expected tcp, tcp4, tcp6, unix or unixpacket)", network, s)
+}
+
+// listenSpec is a --listen value with its options, like
+// "tcp:0.0.0.0:9001?tls=cert.pem,key.pem&token-file=/etc/nosleep/token".
+type listenSpec struct {
+	network  string
+	address  string
+	certFile string   // TLS certificate and key, TLS is off without them
+	keyFile  string   //
+	token    string   // token clients send before their calls, if set
+	users    []string // users allowed at the other end of a unix socket, if set
+}
+
+// parseListenSpec parses a --listen value and its options: tls=CERT,KEY,
+// token=TOKEN or token-file=PATH, and users=NAME,... for unix sockets on Linux.
+func parseListenSpec(s string) (listenSpec, error) {
+	base, options, _ := strings.Cut(s, "?")
+	network, address, err := parseListen(base)
+	if err != nil {
+		return listenSpec{}, err
+	}
+	spec := listenSpec{network: network, address: address}
+	values, err := url.ParseQuery(options)
+	if err != nil {
+		return listenSpec{}, fmt.Errorf("invalid options in listen address %q: %v", s, err)
+	}
+	for key, value := range values {
+		v := value[len(value)-1]
+		switch key {
+		case "tls":
+			var ok bool
+			if spec.certFile, spec.keyFile, ok = strings.Cut(v, ","); !ok || spec.certFile == "" || spec.keyFile == "" {
+				return listenSpec{}, fmt.Errorf("invalid tls option %q in listen address (expected tls=CERT,KEY)", v)
+			}
+		case "token":
+			spec.token = v
+		case "token-file":
+			if spec.token, err = readTokenFile(v); err != nil {
+				return listenSpec{}, err
+			}
+		case "users":
+			if !strings.HasPrefix(network, "unix") {
+				return listenSpec{}, fmt.Errorf("users option in listen address %q requires a unix socket", s)
+			}
+			// peerIdentity returns "" elsewhere, all connections would be denied
+			if runtime.GOOS != "linux" {
+				return listenSpec{}, fmt.Errorf("users option in listen address %q is only supported on Linux", s)
+			}
+			spec.users = strings.Split(v, ",")
+		default:
+			return listenSpec{}, fmt.Errorf("unknown option %q in listen address %q (expected tls, token, token-file or users)", key, s)
+		}
+	}
+	if (values.Has("token") || values.Has("token-file")) && spec.token == "" {
+		return listenSpec{}, fmt.Errorf("empty token in listen address %q", s)
+	}
+	return spec, nil
+}
+
+// listen opens the listener of a spec, with TLS and authentication if
+// configured.
+func (spec listenSpec) listen() (net.Listener, error) {
+	var tlsConfig *tls.Config
+	if spec.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(spec.certFile, spec.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+	listener, err := net.Listen(spec.network, spec.address)
+	if err != nil || (tlsConfig == nil && spec.token == "" && spec.users == nil) {
+		return listener, err
+	}
+	return &secureListener{Listener: listener, tls: tlsConfig, token: spec.token, users: spec.users}, nil
+}
+
+// secureListener applies the options of a --listen value to the connections
+// it accepts: it drops the unix clients that are not allowed, then wraps the
+// others in TLS and requires their token. Handshakes happen on the first read,
+// in the goroutine serving the connection, so that a slow client does not
+// hold up the others.
+type secureListener struct {
+	net.Listener
+	tls   *tls.Config
+	token string
+	users []string
+}
+
+func (l *secureListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.users != nil {
+			if user := peerIdentity(conn); !slices.Contains(l.users, user) {
+				log.Printf("Connection of user %q on %s denied", user, l.Addr())
+				conn.Close() //nolint:errcheck
+				continue
+			}
+		}
+		if l.tls != nil {
+			conn = tls.Server(conn, l.tls)
+		}
+		if l.token != "" {
+			conn = &tokenConn{Conn: conn, token: l.token}
+		}
+		return conn, nil
+	}
+}
+
+// tokenConn requires the client to send "AUTH <token>" on a line before its
+// calls, and answers OK, or DENIED and closes the connection.
+type tokenConn struct {
+	net.Conn
+	token string
+	once  sync.Once
+	err   error
+}
+
+func (c *tokenConn) Read(p []byte) (int, error) {
+	c.once.Do(c.handshake)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.Conn.Read(p)
+}
+
+// NetConn returns the connection the token is read from, see peerIdentity.
+func (c *tokenConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *tokenConn) handshake() {
+	c.SetReadDeadline(time.Now().Add(tokenTimeout)) //nolint:errcheck
+	line, err := readLine(c.Conn)
+	c.SetReadDeadline(time.Time{}) //nolint:errcheck
+	if err != nil {
+		c.err = err
+		return
+	}
+	token, _ := strings.CutPrefix(line, "AUTH ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(c.token)) != 1 {
+		log.Printf("Connection from %s denied: invalid token", c.RemoteAddr())
+		fmt.Fprintln(c.Conn, tokenDenied) //nolint:errcheck
+		c.err = newError(ErrDenied, "invalid token")
+		return
+	}
+	_, c.err = fmt.Fprintln(c.Conn, tokenAccepted)
+}
+
+// readLine reads a line byte by byte, so that nothing after it is consumed.
+func readLine(conn net.Conn) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for len(line) < maxTokenLine {
+		if _, err := conn.Read(b); err != nil {
+			return "", err
+		}
+		if b[0] == '\n' {
+			return strings.TrimSuffix(string(line), "\r"), nil
+		}
+		line = append(line, b[0])
+	}
+	return "", errors.New("line too long")
+}
+
+// readTokenFile reads a token from a file, without the final newline.
+func readTokenFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// dialOptions are the options of a connection to a listener with the tls or
+// token options.
+type dialOptions struct {
+	tlsCA      string // CA certificates verifying the server, TLS if set
+	serverName string // name in the certificate of the server
+	token      string // token sent to the server, if set
+}
+
+// dial connects to a listener with the options. With a timeout, the
+// connection keeps a deadline covering the TLS handshake and the token.
+func (o dialOptions) dial(network, address string, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout(network, address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout)) //nolint:errcheck
+	}
+	if o.tlsCA != "" {
+		pem, err := os.ReadFile(o.tlsCA)
+		if err != nil {
+			conn.Close() //nolint:errcheck
+			return nil, err
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			conn.Close() //nolint:errcheck
+			return nil, fmt.Errorf("%s: no certificate", o.tlsCA)
+		}
+		conn = tls.Client(conn, &tls.Config{RootCAs: roots, ServerName: o.serverName, MinVersion: tls.VersionTLS12})
+	}
+	if o.token != "" {
+		if err := sendToken(conn, o.token); err != nil {
+			conn.Close() //nolint:errcheck
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// dialServer connects a client command to the server of the network, address
+// and port options, with TLS if --tls-ca is given and sending --token.
+func dialServer(cfg *Config) (*rpc.Client, error) {
+	options := dialOptions{tlsCA: cfg.tlsCA, serverName: cfg.address, token: cfg.token}
+	conn, err := options.dial(cfg.network, serverAddress(cfg), 0)
+	if err != nil {
+		return nil, err
+	}
+	return rpc.NewClient(conn), nil
+}
+
+// sendToken authenticates a connection to a listener with a token.
+func sendToken(conn net.Conn, token string) error {
+	if _, err := fmt.Fprintf(conn, "AUTH %s\n", token); err != nil {
+		return err
+	}
+	reply, err := readLine(conn)
+	switch {
+	case err != nil:
+		return err
+	case reply == tokenDenied:
+		return newError(ErrDenied, "invalid token")
+	case reply != tokenAccepted:
+		return fmt.Errorf("unexpected reply %q to the token", reply)
+	}
+	return nil
+}
+
+// serverAddress returns the address of the network, address and port options,
+// that the server listens on and client commands dial: the address option
+// alone for unix sockets, with the port otherwise.
+func serverAddress(cfg *Config) string {
+	if strings.HasPrefix(cfg.network, "unix") {
+		return cfg.address
+	}
+	return fmt.Sprintf("%s:%d", cfg.address, cfg.port)
+}
+
+// listenAll opens a listener for each --listen value with its options, and closes the ones
+// already open if one fails.
+func listenAll(specs []string) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, s := range specs {
+		spec, err := parseListenSpec(s)
+		if err == nil {
+			var listener net.Listener
+			if listener, err = spec.listen(); err == nil {
+				listeners = append(listeners, listener)
+				continue
+			}
+		}
+		for _, l := range listeners {
+			l.Close() //nolint:errcheck
+		}
+		return nil, err
+	}
+	return listeners, nil
+}
+
+// multiListener accepts the connections of several listeners, so that the
+// server can be reached eg. on a unix socket and on TCP. Closing it closes
+// all of them.
+type multiListener struct {
+	listeners []net.Listener
+	accepted  chan acceptResult
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+// newMultiListener starts accepting the connections of the listeners.
+func newMultiListener(listeners []net.Listener) *multiListener {
+	l := &multiListener{
+		listeners: listeners,
+		accepted:  make(chan acceptResult),
+		closed:    make(chan struct{}),
+	}
+	for _, listener := range listeners {
+		go l.accept(listener)
+	}
+	return l
+}
+
+// accept passes the connections and errors of a listener to Accept, until the
+// listener is closed.
+func (l *multiListener) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		select {
+		case l.accepted <- acceptResult{conn, err}:
+		case <-l.closed:
+			if conn != nil {
+				conn.Close() //nolint:errcheck
+			}
+			return
+		}
+	}
+}
+
+func (l *multiListener) Accept() (net.Conn, error) {
+	select {
+	case r := <-l.accepted:
+		return r.conn, r.err
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes all the listeners, and returns the first error.
+func (l *multiListener) Close() error {
+	err := net.ErrClosed
+	l.closeOnce.Do(func() {
+		err = nil
+		close(l.closed)
+		for _, listener := range l.listeners {
+			if closeErr := listener.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+	})
+	return err
+}
+
+// Addr returns the address of the first listener.
+func (l *multiListener) Addr() net.Addr {
+	return l.listeners[0].Addr()
+}
+
+// logListeners logs the addresses the server is listening on.
+// listenerTokens returns the tokens required by the listeners, which the web
+// API accepts as well.
+func listenerTokens(listeners []net.Listener) []string {
+	var tokens []string
+	for _, listener := range listeners {
+		if l, ok := listener.(*secureListener); ok && l.token != "" {
+			tokens = append(tokens, l.token)
+		}
+	}
+	return tokens
+}
+
+func logListeners(listeners []net.Listener) {
+	for _, listener := range listeners {
+		log.Printf("RPC server listening on %s (%s)", listener.Addr(), listener.Addr().Network())
+	}
+}
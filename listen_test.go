@@ -0,0 +1,309 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/rpc"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestParseListen(t *testing.T) {
+	tests := []struct {
+		spec             string
+		network, address string
+		wantErr          bool
+	}{
+		{"unix:/run/nosleep.sock", "unix", "/run/nosleep.sock", false},
+		{"tcp:127.0.0.1:9001", "tcp", "127.0.0.1:9001", false},
+		{"tcp6:[::1]:9001", "tcp6", "[::1]:9001", false},
+		{"127.0.0.1:9001", "", "", true},
+		{"udp:127.0.0.1:9001", "", "", true},
+		{"tcp:", "", "", true},
+	}
+	for _, tt := range tests {
+		network, address, err := parseListen(tt.spec)
+		if (err != nil) != tt.wantErr || network != tt.network || address != tt.address {
+			t.Errorf("parseListen(%q) = %q, %q, %v", tt.spec, network, address, err)
+		}
+	}
+}
+
+func TestMultiListener(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "nosleep.sock")
+	listeners, err := listenAll([]string{"unix:" + socket, "tcp:127.0.0.1:0"})
+	if err != nil {
+		t.Fatalf("listenAll failed: %v", err)
+	}
+	manager := &ExecStateManager{listener: newMultiListener(listeners)}
+	manager.Start()
+	defer manager.Stop()
+
+	rpc.DefaultServer = rpc.NewServer()
+	if err := rpc.Register(manager); err != nil {
+		t.Fatalf("rpc.Register failed: %v", err)
+	}
+	accepted := make(chan struct{})
+	go func() {
+		defer close(accepted)
+		for {
+			conn, err := manager.listener.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					t.Errorf("unexpected accept error: %v", err)
+				}
+				return
+			}
+			manager.serveConn(conn)
+		}
+	}()
+
+	local, err := rpc.Dial("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to dial the unix socket: %v", err)
+	}
+	defer local.Close()
+	remote, err := rpc.Dial("tcp", listeners[1].Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial the TCP socket: %v", err)
+	}
+	defer remote.Close()
+
+	for _, client := range []*rpc.Client{local, remote} {
+		if err := client.Call("ExecStateManager.Ping", ExecStateRequest{}, &ExecStateReply{}); err != nil {
+			t.Errorf("Ping RPC call failed: %v", err)
+		}
+	}
+
+	// Shutdown closes all the listeners
+	if err := local.Call("ExecStateManager.Shutdown", ExecStateRequest{}, &ExecStateReply{}); err != nil {
+		t.Fatalf("Shutdown RPC call failed: %v", err)
+	}
+	<-accepted
+	if _, err := net.Dial("unix", socket); err == nil {
+		t.Error("expected the unix socket to be closed")
+	}
+	if _, err := net.Dial("tcp", listeners[1].Addr().String()); err == nil {
+		t.Error("expected the TCP socket to be closed")
+	}
+}
+
+func TestListenAllFailure(t *testing.T) {
+	first, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	socket := filepath.Join(t.TempDir(), "nosleep.sock")
+	if _, err := listenAll([]string{"unix:" + socket, "tcp:" + first.Addr().String()}); err == nil {
+		t.Fatal("expected an error for an address in use")
+	}
+	// the listeners opened before the failure are closed
+	if _, err := net.Dial("unix", socket); err == nil {
+		t.Error("expected the unix socket to be closed")
+	}
+}
+
+func TestServeUnixSocket(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix sockets are not used on Windows")
+	}
+	rpc.DefaultServer = rpc.NewServer()
+	cfg := &Config{network: "unix", address: filepath.Join(t.TempDir(), "nosleep.sock"), port: DEFAULT_PORT, drainTimeout: defaultDrainTimeout}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := serve(cfg); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// the server listens on the path alone, which client commands dial
+	var client *rpc.Client
+	waitFor(t, "the unix socket", func() bool {
+		var err error
+		client, err = rpc.Dial(cfg.network, serverAddress(cfg))
+		return err == nil
+	})
+	defer client.Close()
+	var reply ExecStateReply
+	if err := client.Call("ExecStateManager.Read", ExecStateRequest{}, &reply); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if err := client.Call("ExecStateManager.Shutdown", ExecStateRequest{}, &ExecStateReply{}); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	<-done
+}
+
+func TestParseListenSpec(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// users are only supported on Linux
+	linux := runtime.GOOS == "linux"
+	var users listenSpec
+	if linux {
+		users = listenSpec{network: "unix", address: "/run/nosleep.sock", users: []string{"alice", "bob"}}
+	}
+	tests := []struct {
+		spec    string
+		want    listenSpec
+		wantErr bool
+	}{
+		{"tcp:127.0.0.1:9001", listenSpec{network: "tcp", address: "127.0.0.1:9001"}, false},
+		{"tcp:0.0.0.0:9001?tls=cert.pem,key.pem&token=abc", listenSpec{network: "tcp", address: "0.0.0.0:9001", certFile: "cert.pem", keyFile: "key.pem", token: "abc"}, false},
+		{"tcp:0.0.0.0:9001?token-file=" + tokenFile, listenSpec{network: "tcp", address: "0.0.0.0:9001", token: "s3cret"}, false},
+		{"unix:/run/nosleep.sock?users=alice,bob", users, !linux},
+		{"tcp:127.0.0.1:9001?users=alice", listenSpec{}, true},
+		{"tcp:127.0.0.1:9001?tls=cert.pem", listenSpec{}, true},
+		{"tcp:127.0.0.1:9001?token=", listenSpec{}, true},
+		{"tcp:127.0.0.1:9001?auth=none", listenSpec{}, true},
+	}
+	for _, tt := range tests {
+		spec, err := parseListenSpec(tt.spec)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(spec, tt.want) {
+			t.Errorf("parseListenSpec(%q) = %+v, %v", tt.spec, spec, err)
+		}
+	}
+}
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1 and its key.
+func writeTestCert(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeFixture(t, dir, map[string]string{
+		"cert.pem": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		"key.pem":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})),
+	})
+	return certFile, keyFile
+}
+
+func TestSecureListener(t *testing.T) {
+	certFile, keyFile := writeTestCert(t, t.TempDir())
+	listeners, err := listenAll([]string{"tcp:127.0.0.1:0?tls=" + certFile + "," + keyFile + "&token=s3cret"})
+	if err != nil {
+		t.Fatalf("listenAll failed: %v", err)
+	}
+	manager := &ExecStateManager{listener: listeners[0]}
+	manager.Start()
+	defer manager.Stop()
+	rpc.DefaultServer = rpc.NewServer()
+	if err := rpc.Register(manager); err != nil {
+		t.Fatalf("rpc.Register failed: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := manager.listener.Accept()
+			if err != nil {
+				return
+			}
+			manager.serveConn(conn)
+		}
+	}()
+	// the connections are served by rpc.DefaultServer, replaced by the next test
+	defer manager.drain(time.Second)
+	defer manager.listener.Close()
+
+	port := manager.listener.Addr().(*net.TCPAddr).Port
+	cfg := &Config{network: "tcp", address: "127.0.0.1", port: port, tlsCA: certFile, token: "s3cret"}
+	client, err := dialServer(cfg)
+	if err != nil {
+		t.Fatalf("dialServer failed: %v", err)
+	}
+	defer client.Close()
+	if err := client.Call("ExecStateManager.Ping", ExecStateRequest{}, &ExecStateReply{}); err != nil {
+		t.Errorf("Ping with TLS and token failed: %v", err)
+	}
+
+	wrong := *cfg
+	wrong.token = "guess"
+	if _, err := dialServer(&wrong); ErrorCodeOf(err) != ErrDenied {
+		t.Errorf("expected a wrong token to be denied, got %v", err)
+	}
+	plain := *cfg
+	plain.tlsCA = ""
+	if client, err := dialServer(&plain); err == nil {
+		client.Close()
+		t.Error("expected a client without TLS to fail")
+	}
+}
+
+func TestListenUsers(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are only read on Linux")
+	}
+	current, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+	dir := t.TempDir()
+	allowed := filepath.Join(dir, "allowed.sock")
+	denied := filepath.Join(dir, "denied.sock")
+	listeners, err := listenAll([]string{"unix:" + allowed + "?users=" + current.Username, "unix:" + denied + "?users=nobody-else"})
+	if err != nil {
+		t.Fatalf("listenAll failed: %v", err)
+	}
+	manager := &ExecStateManager{listener: newMultiListener(listeners)}
+	manager.Start()
+	defer manager.Stop()
+	rpc.DefaultServer = rpc.NewServer()
+	if err := rpc.Register(manager); err != nil {
+		t.Fatalf("rpc.Register failed: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := manager.listener.Accept()
+			if err != nil {
+				return
+			}
+			manager.serveConn(conn)
+		}
+	}()
+	// the connections are served by rpc.DefaultServer, replaced by the next test
+	defer manager.drain(time.Second)
+	defer manager.listener.Close()
+
+	for socket, ok := range map[string]bool{allowed: true, denied: false} {
+		client, err := rpc.Dial("unix", socket)
+		if err != nil {
+			t.Fatalf("Failed to dial %s: %v", socket, err)
+		}
+		err = client.Call("ExecStateManager.Ping", ExecStateRequest{}, &ExecStateReply{})
+		client.Close()
+		if (err == nil) != ok {
+			t.Errorf("%s: expected allowed %v, got %v", filepath.Base(socket), ok, err)
+		}
+	}
+}
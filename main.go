@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 )
 
 const DEFAULT_PORT = 9001
@@ -19,13 +21,52 @@ var (
 
 // flags
 type Config struct {
-	network string
-	address string
-	port    int
-	display bool
-	logPath string
-	help    bool
-	version bool
+	network      string
+	listens      stringList
+	token        string
+	tlsCA        string
+	upstreams    stringList
+	address      string
+	port         int
+	display      bool
+	logPath      string
+	schedules    stringList
+	cpuLimit     float64
+	diskLimit    float64
+	cooldown     time.Duration
+	procRoot     string
+	netLimit     float64
+	netIfaces    stringList
+	netRoot      string
+	watches      stringList
+	sessPorts    string
+	holdDir      string
+	holdAge      time.Duration
+	powerRoot    string
+	downgrade    bool
+	releaseAt    int
+	httpAddr     string
+	web          bool
+	auditPath    string
+	history      string
+	reassert     time.Duration
+	drainTimeout time.Duration
+	idleTimeout  time.Duration
+	exitOnEmpty  bool
+	help         bool
+	version      bool
+}
+
+// stringList collects the values of a repeatable flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
 }
 
 func initFlags() *Config {
@@ -36,10 +77,39 @@ func initFlags() *Config {
 	flag.StringVar(&cfg.address, "address", "127.0.0.1", "Bind address")
 	flag.IntVar(&cfg.port, "p", DEFAULT_PORT, "")
 	flag.IntVar(&cfg.port, "port", DEFAULT_PORT, "RPC server listening port")
+	flag.Var(&cfg.listens, "listen", "Listen on this network:address[?options] (repeatable)")
+	flag.StringVar(&cfg.token, "token", "", "Token sent to the server by client commands")
+	flag.StringVar(&cfg.tlsCA, "tls-ca", "", "Connect client commands with TLS, trusting the certificates in this file")
 	flag.BoolVar(&cfg.display, "d", false, "")
 	flag.BoolVar(&cfg.display, "display", false, "Force display to stay on")
 	flag.StringVar(&cfg.logPath, "l", "", "")
 	flag.StringVar(&cfg.logPath, "log", "", "Write logs to a file instead of stdout")
+	flag.Var(&cfg.schedules, "s", "")
+	flag.Var(&cfg.schedules, "schedule", "Keep awake during a weekly time window (repeatable)")
+	flag.Float64Var(&cfg.cpuLimit, "cpu-threshold", 0, "Keep awake while CPU utilisation is above this percentage")
+	flag.Float64Var(&cfg.diskLimit, "disk-threshold", 0, "Keep awake while disk I/O is above this rate in MB/s")
+	flag.DurationVar(&cfg.cooldown, "cooldown", 5*time.Minute, "Keep awake this long after activity or traffic stopped")
+	flag.StringVar(&cfg.procRoot, "proc-root", "", "Read activity counters from stat and diskstats files in this directory")
+	flag.Float64Var(&cfg.netLimit, "net-threshold", 0, "Keep awake while network traffic is above this rate in MB/s")
+	flag.Var(&cfg.netIfaces, "net-interface", "Only watch the traffic of this interface (repeatable)")
+	flag.StringVar(&cfg.netRoot, "net-root", "", "Read interface counters from */statistics in this directory")
+	flag.Var(&cfg.watches, "w", "")
+	flag.Var(&cfg.watches, "watch", "Keep awake while a matching process is running (repeatable)")
+	flag.StringVar(&cfg.sessPorts, "session-ports", "", "Keep awake while remote sessions are connected to these ports")
+	flag.StringVar(&cfg.holdDir, "hold-dir", "", "Keep awake while this directory contains files")
+	flag.DurationVar(&cfg.holdAge, "hold-max-age", 24*time.Hour, "Ignore hold files older than this")
+	flag.BoolVar(&cfg.downgrade, "battery-downgrade", false, "Downgrade Display to System mode on battery")
+	flag.IntVar(&cfg.releaseAt, "battery-release", 0, "Allow sleep on battery below this charge percentage")
+	flag.StringVar(&cfg.powerRoot, "power-root", "", "Read power supplies from this directory")
+	flag.StringVar(&cfg.httpAddr, "http", "", "Serve HTTP endpoints (event stream) on this address")
+	flag.BoolVar(&cfg.web, "web", false, "Serve the web admin page on the HTTP endpoints")
+	flag.DurationVar(&cfg.idleTimeout, "idle-timeout", 0, "Shut down after this long without registrations, holds, schedule windows or forced modes")
+	flag.BoolVar(&cfg.exitOnEmpty, "exit-on-empty", true, "Shut down when the last registered process unregisters")
+	flag.DurationVar(&cfg.drainTimeout, "drain-timeout", defaultDrainTimeout, "How long to wait for calls in progress on shutdown")
+	flag.StringVar(&cfg.auditPath, "audit", "", "Append a record of every state change call to this file")
+	flag.StringVar(&cfg.history, "history", "", "Keep the history of mode intervals in this file")
+	flag.Var(&cfg.upstreams, "upstream", "Relay Forward calls to this name=host:port (repeatable)")
+	flag.DurationVar(&cfg.reassert, "reassert", 0, "Re-assert the state at this interval and detect drift (0 to disable)")
 	flag.BoolVar(&cfg.help, "?", false, "")
 	flag.BoolVar(&cfg.help, "help", false, "displays this help message")
 	flag.BoolVar(&cfg.version, "v", false, "")
@@ -52,28 +122,183 @@ func main() {
 	cfg := initFlags()
 	flag.Usage = func() {
 		fmt.Fprintln(os.Stderr, "Usage: "+name+` [OPTIONS]
+       `+name+` audit [--since time] [--until time] [--identity name] [--json] FILE
+       `+name+` [-n network] [-a address] [-p port] report [--since time] [--until time]
+              [--format table|csv|json] [--history FILE]
+       `+name+` [-n network] [-a address] [-p port] hello [--json]
+       `+name+` fleet [--timeout duration] [--client name] FILE COMMAND [PID]
+       `+name+` [-n network] [-a address] [-p port] top [--interval duration]
 
 Sets ThreadExecutionState to (ES_CONTINUOUS | ES_SYSTEM_REQUIRED) and
 starts an RPC server on ADDRESS:PORT (default: 127.0.0.1:`+fmt.Sprintf("%d", DEFAULT_PORT)+`).
 
 You can manage the server using RPC calls to control thread execution states
 where possible commands are: Clear, Display, System, Critical, Read and Shutdown.
+Ping returns the health of the server (see also /healthz and /readyz).
+Hello returns the version, protocol version and capabilities of the server,
+for clients to check that they are compatible before calling other methods.
+Failed calls return an error prefixed with a code, eg. "not-found: process
+1234 is not registered" for Unregister of a process that is not registered.
+
+With --upstream, the server also relays calls to other servers: Forward calls
+a method on the upstreams by name, or on all of them, and returns the reply
+or error of each one, eg. to keep a group of machines awake with one call.
+The fleet command does the same from the command line, for the servers of an
+inventory file: system, display, clear, register PID, unregister PID or read.
+
+These commands are force overrides of a single global mode. Callers sharing
+the server should rather Acquire a counted handle on a mode and Release it
+when done: the mode stays in effect until its last handle is released.
+Remote clients, whose PID means nothing on this computer, can acquire a lease
+instead, renewed by Heartbeat calls and dropped after missed heartbeats.
+A handle taken by Hold is released when the connection of the caller closes.
 
 Another way to control the server is by registering/unregistering processes.
-The server will automatically shut down when the last process is unregistered.
+The server will automatically shut down when the last process is unregistered,
+unless --exit-on-empty=false is given. With --idle-timeout, it also shuts down
+after a period without registered processes, holds, schedule windows open or
+modes forced by clients.
+
+On Linux, the state is held with systemd inhibitor locks. The server supports
+systemd socket activation and notifications (READY, STATUS and WATCHDOG).
+With several --listen options or activated sockets, the server accepts clients
+on all of them, eg. local tools on a unix socket and remote agents on TCP.
+
+On shutdown (Shutdown command, CTRL+C, SIGTERM or SIGHUP), the server stops
+accepting connections, notifies subscribers, waits for the calls in progress
+to return and then clears the state.
+
+Schedule rules keep the computer awake only during weekly time windows and
+allow sleep otherwise. They can be managed with ListSchedules, AddSchedule
+and RemoveSchedule.
+
+Conditions keep the computer awake (System mode) while they are met, on top
+of the mode set via RPC, and for a cool-down period after. Their readings and
+decisions are returned by Read. Processes matching a watch rule, remote
+sessions and hold files are returned alongside the registered processes.
+
+A power policy can downgrade or release the state while running on battery.
+Its decisions are logged and returned by Read.
+
+With --reassert, the state is re-asserted periodically. When the state found
+differs from the one applied, a drift is counted and reported by Read, Ping,
+the event stream and the /metrics endpoint.
+
+Clients can subscribe to state changes (mode changes, registrations, holds,
+expiries, drift and shutdown) with the Watch command, which waits for events after
+a cursor, or with the Server-Sent Events stream of the HTTP server.
+
+The audit log records who changed the state and when: the method, the
+resulting mode, the remote address, the user at the other end of a unix
+socket (Linux) and the client name sent in the request. It can be queried
+with the QueryAudit command, or offline with the audit command.
+
+The server keeps a history of the modes applied and of the registered
+processes and holds keeping them. The report command (or Report RPC) sums
+up the time spent in each mode and held awake by each registrant.
+
+The top command shows the state of a server live in the terminal: mode,
+registrations, handles and leases, conditions and recent events. Keys switch
+the mode, and unregister or release the selected entry.
 
 OPTIONS:
 
   -n, --network string
           Network type: tcp, tcp4, tcp6, unix or unixpacket (default "tcp")
   -a, --address string
-          Bind address (default 127.0.0.1)
+          Bind address, or socket path for unix networks (default 127.0.0.1)
   -p, --port int
           RPC server listening port (default 9001)
+      --listen network:address[?options]
+          Listen on this address instead of the network, address and port
+          options, eg. unix:/run/nosleep.sock, tcp:127.0.0.1:9001 or
+          tcp6:[::1]:9001 (repeatable). Options, separated by &:
+            tls=CERT,KEY     serve TLS with this certificate and key
+            token=TOKEN      require clients to send this token
+            token-file=PATH  require the token read from this file
+            users=NAME,...   only accept these users on a unix socket (Linux)
+      --token token
+          Token sent to the server by the client commands
+      --tls-ca file
+          Connect the client commands with TLS, trusting the certificates in
+          this file
   -d, --display
           Force display to stay on
   -l, --log path
           Write logs to a file instead of stdout
+  -s, --schedule rule
+          Keep awake during a weekly time window, eg. "mon-fri 22:00-06:00 system"
+          (repeatable, mode is system, display or critical)
+      --cpu-threshold percent
+          Keep awake while CPU utilisation is above this percentage
+      --disk-threshold MB/s
+          Keep awake while disk reads and writes are above this rate
+      --cooldown duration
+          Keep awake this long after activity or network traffic dropped below
+          their thresholds (default 5m0s)
+      --proc-root path
+          Read activity counters from 'stat' and 'diskstats' files in this
+          directory (default /proc on Linux, system counters on Windows)
+      --net-threshold MB/s
+          Keep awake while network traffic (received and sent) is above this rate
+      --net-interface name
+          Only watch the traffic of this interface (repeatable, default all)
+      --net-root path
+          Read interface counters from '*/statistics' in this directory
+          (default /sys/class/net on Linux, interface table on Windows)
+  -w, --watch pattern[=mode]
+          Keep awake while a process matching the name or path pattern is running,
+          eg. "ffmpeg" or "C:\Windows\System32\robocopy.exe=display" (repeatable,
+          mode is system, display or critical, default system)
+      --session-ports list
+          Keep awake while remote sessions are connected to these local ports,
+          eg. 22,3389 for SSH and RDP
+      --hold-dir path
+          Keep awake while this directory contains files. A file may contain a
+          TTL like "2h", or have a modification time in the future
+      --hold-max-age duration
+          Ignore hold files not modified for this long (default 24h0m0s)
+      --battery-downgrade
+          Downgrade Display to System mode while running on battery
+      --battery-release percent
+          Release all modes and holds while running on battery below this charge
+      --power-root path
+          Read power supplies from this directory (default /sys/class/power_supply
+          on Linux, system power status on Windows)
+      --http address
+          Serve HTTP endpoints on this address, eg. 127.0.0.1:9002:
+            /events   Server-Sent Events stream of state changes
+            /healthz  health of the server (503 when degraded or stopped)
+            /readyz   readiness of the server (503 when not healthy or not listening)
+            /metrics  counters in the Prometheus text format
+      --web
+          Serve the web admin page on / and its API on /api/ with the --http endpoints.
+          The API requires a token of the --listen sockets if they have one, and
+          --http on another interface than loopback requires one
+      --reassert duration
+          Re-assert the state at this interval and detect drift, eg. 1m, 0 to
+          disable (default 0s)
+      --idle-timeout duration
+          Shut down after this long without registered processes, holds (conditions
+          met or cooling down), schedule windows open or modes set by clients, 0 to
+          disable (default 0s)
+      --exit-on-empty
+          Shut down when the last registered process unregisters, use
+          --exit-on-empty=false for long-lived daemons (default true)
+      --drain-timeout duration
+          How long to wait for the calls in progress on shutdown before closing
+          the connections (default 10s)
+      --audit path
+          Append a JSON record of every call that changes the state to this file
+      --history path
+          Append the mode intervals to this file as they end, and load them on
+          startup so that reports cover restarts (last 4096 intervals)
+      --upstream name=host:port[?options]
+          Relay the Forward calls to the nosleep-server at this address
+          (repeatable), with the options of a --listen socket it uses:
+            tls-ca=FILE      verify its certificate with these CA certificates
+            token=TOKEN      send this token
+            token-file=PATH  send the token read from this file
   -?, --help
           displays this help message
   -v, --version
@@ -85,6 +310,35 @@ EXAMPLES:`)
 
   will set ThreadExecutionState to (ES_CONTINUOUS | ES_SYSTEM_REQUIRED | ES_DISPLAY_REQUIRED)
   and start an RPC server listening on 127.0.0.1:9015.`)
+
+		fmt.Fprintln(os.Stderr, "\n  "+name+` --schedule "mon-fri 22:00-06:00" --schedule "sat 08:00-20:00 display"
+
+  will keep the computer awake on weeknights from 22:00 to 06:00, with the
+  display on saturdays from 08:00 to 20:00, and allow sleep otherwise.`)
+
+		fmt.Fprintln(os.Stderr, "\n  "+name+` audit --since 24h --identity alice nosleep-audit.jsonl
+
+  will print the state changes made by alice (user or client name) in the
+  last 24 hours.`)
+
+		fmt.Fprintln(os.Stderr, "\n  "+name+` report --since 168h --format csv
+
+  will print the hours spent in each mode and held awake by each registrant
+  over the last week, as reported by the server on 127.0.0.1:9001.`)
+
+		fmt.Fprintln(os.Stderr, "\n  "+name+` hello
+
+  will print the version and capabilities of the server on 127.0.0.1:9001,
+  and fail if this client is not compatible with it.`)
+
+		fmt.Fprintln(os.Stderr, "\n  "+name+` fleet --timeout 10s rack4.txt system
+
+  will set the System mode on all the servers listed in rack4.txt, print a
+  summary and exit with status 1 if any of them failed.`)
+
+		fmt.Fprintln(os.Stderr, "\n  "+name+` -a 10.0.4.1 top
+
+  will show the state of the server on 10.0.4.1:9001, refreshed every second.`)
 	}
 	flag.Parse()
 
@@ -93,6 +347,19 @@ EXAMPLES:`)
 		return
 	}
 
+	switch flag.Arg(0) {
+	case "audit":
+		os.Exit(auditCommand(flag.Args()[1:]))
+	case "report":
+		os.Exit(reportCommand(cfg, flag.Args()[1:]))
+	case "hello":
+		os.Exit(helloCommand(cfg, flag.Args()[1:]))
+	case "fleet":
+		os.Exit(fleetCommand(cfg, flag.Args()[1:]))
+	case "top":
+		os.Exit(topCommand(cfg, flag.Args()[1:]))
+	}
+
 	if cfg.help {
 		flag.Usage()
 		return
@@ -115,5 +382,7 @@ EXAMPLES:`)
 	}
 
 	log.Printf("%s %s starting...\n", name, version)
-	serve(cfg)
+	if err := serve(cfg); err != nil {
+		log.Fatalf("Server error: %v", err)
+	}
 }
@@ -1,13 +1,29 @@
 package main
 
 import (
+	"cmp"
+	"errors"
 	"log"
+	"maps"
 	"net"
 	"runtime"
+	"slices"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
+// errManagerStopped is returned when a state change is requested after Stop().
+var errManagerStopped = &Error{Code: ErrShuttingDown, Err: errors.New("ExecStateManager is stopped")}
+
+// Registration is a process registered with Register.
+type Registration struct {
+	Process    int
+	Client     string
+	Registered time.Time
+	Upstream   string // server the process is registered on, in aggregated Read replies of Forward
+}
+
 type execStateCommand struct {
 	flags   uint32
 	errChan chan error
@@ -20,7 +36,34 @@ type ExecStateManager struct {
 	mgrShutdownCh chan struct{}
 	listener      net.Listener
 	processesMu   sync.Mutex
-	processes     map[int]struct{}
+	processes     map[int]Registration
+	schedule      schedule
+	clock         func() time.Time                   // defaults to time.Now, tests may inject their own
+	setState      func(flags uint32) (uint32, error) // defaults to SetThreadExecutionState
+	stateMu       sync.Mutex
+	mode          uint32            // flags set via RPC or the schedule
+	forced        bool              // mode set by a client, which keeps the server from being idle
+	holds         map[string]uint32 // flags held on top of mode, by owner
+	applied       uint32            // flags last applied successfully
+	conditions    []*conditionMonitor
+	power         *powerPolicy
+	events        eventLog
+	history       history
+	audit         *auditLog
+	started       time.Time
+	listening     atomic.Bool // set while the RPC server accepts connections
+	backend       backendStatus
+	// interval at which the OS thread re-asserts the state, 0 to disable
+	reassertInterval time.Duration
+	connsMu          sync.Mutex
+	conns            map[*connCodec]struct{} // connections being served
+	connsWG          sync.WaitGroup
+	shutdownOnce     sync.Once
+	keepOnEmpty      bool // do not shut down when the last process unregisters
+	handles          handleTable
+	upstreams        []Upstream // servers Forward relays calls to
+	web              bool       // serve the web admin page on the HTTP endpoints
+	webTokens        []string   // tokens of the --listen sockets, required by the web API
 }
 
 // Start launches the dedicated OS thread goroutine
@@ -28,27 +71,31 @@ func (m *ExecStateManager) Start() {
 	m.commandCh = make(chan execStateCommand)
 	m.mgrShutdownCh = make(chan struct{})
 	if m.processes == nil {
-		m.processes = make(map[int]struct{})
+		m.processes = make(map[int]Registration)
 	}
+	if m.holds == nil {
+		m.holds = make(map[string]uint32)
+	}
+	m.started = m.now()
 
 	go func() {
 		// Lock goroutine to its current OS thread
 		runtime.LockOSThread()
 		defer runtime.UnlockOSThread()
 
+		var reassert <-chan time.Time
+		if m.reassertInterval > 0 {
+			ticker := time.NewTicker(m.reassertInterval)
+			defer ticker.Stop()
+			reassert = ticker.C
+		}
+
 		for {
 			select {
 			case cmd := <-m.commandCh:
-				// Call Windows API on this thread
-				ret, err := SetThreadExecutionState(cmd.flags | ES_CONTINUOUS)
-				if err != nil {
-					log.Printf("SetThreadExecutionState error: %v", err)
-					atomic.StoreUint32(&m.previousState, 0)
-				} else {
-					// Please note that return value is the PREVIOUS state
-					atomic.StoreUint32(&m.previousState, uint32(ret))
-				}
-				cmd.errChan <- err
+				cmd.errChan <- m.assertState(cmd.flags, false)
+			case <-reassert:
+				m.reassertState()
 			case <-m.mgrShutdownCh:
 				return
 			}
@@ -58,26 +105,115 @@ func (m *ExecStateManager) Start() {
 
 // Clears state. This function is meant to be called via defer() right after Start().
 func (m *ExecStateManager) Stop() {
+	m.notifyShutdown()
 	close(m.mgrShutdownCh)
 
-	if _, err := SetThreadExecutionState(ES_CONTINUOUS); err != nil {
+	if _, err := m.setThreadState(ES_CONTINUOUS); err != nil {
 		log.Printf("SetThreadExecutionState error during Stop: %v", err)
 	}
 	log.Println("ThreadExecutionState cleared.")
 }
 
+// notifyShutdown publishes the shutdown event, once.
+func (m *ExecStateManager) notifyShutdown() {
+	m.shutdownOnce.Do(func() {
+		m.publish(Event{Type: EventShutdown})
+	})
+}
+
 // getAtomicState atomically returns the previous flags value
 func (m *ExecStateManager) getAtomicState() uint32 {
 	return atomic.LoadUint32(&m.previousState)
 }
 
-// setAtomicState atomically sets the flags value
-func (m *ExecStateManager) setAtomicState(flags uint32, reply *ExecStateReply) error {
-	errChan := make(chan error)
-	m.commandCh <- execStateCommand{flags: flags, errChan: errChan}
+// setAtomicState atomically sets the flags value, holds stay in effect on top of it.
+// forced tells whether a client set the mode.
+func (m *ExecStateManager) setAtomicState(flags uint32, forced bool, reply *ExecStateReply) error {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+
+	m.mode = flags
+	m.forced = forced && flags != 0
+	return m.applyLocked(m.effectiveLocked(), reply)
+}
+
+// effectiveLocked combines the mode with all holds, as allowed by the power
+// policy. The caller must hold stateMu.
+func (m *ExecStateManager) effectiveLocked() uint32 {
+	flags := m.mode
+	for _, f := range m.holds {
+		flags |= f
+	}
+	return m.power.apply(flags)
+}
+
+// refreshState re-applies the effective state if it changed since it was last
+// applied, eg. because the power policy changed.
+func (m *ExecStateManager) refreshState() error {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+
+	if flags := m.effectiveLocked(); flags != m.applied {
+		return m.applyLocked(flags, &ExecStateReply{})
+	}
+	return nil
+}
+
+// applyLocked sends the flags to the OS thread. The caller must hold stateMu.
+func (m *ExecStateManager) applyLocked(flags uint32, reply *ExecStateReply) error {
+	errChan := make(chan error, 1)
+	select {
+	case m.commandCh <- execStateCommand{flags: flags, errChan: errChan}:
+	case <-m.mgrShutdownCh:
+		return errManagerStopped
+	}
 	err := <-errChan
+	if err == nil && flags != m.applied {
+		m.applied = flags
+		m.publish(Event{Type: EventMode, Flags: flags, Detail: modeName(flags)})
+	}
 	reply.Flags = m.getAtomicState()
-	return err
+	return withCode(ErrBackend, err)
+}
+
+// appliedFlags returns the flags last applied successfully
+func (m *ExecStateManager) appliedFlags() uint32 {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+
+	return m.applied
+}
+
+// setThreadState calls the backend, on the calling thread
+func (m *ExecStateManager) setThreadState(flags uint32) (uint32, error) {
+	if m.setState != nil {
+		return m.setState(flags)
+	}
+	return SetThreadExecutionState(flags)
+}
+
+// now returns the current time from the manager's clock
+func (m *ExecStateManager) now() time.Time {
+	if m.clock != nil {
+		return m.clock()
+	}
+	return time.Now()
+}
+
+// poll calls check now and then every interval, until the manager stops.
+// The periodic monitors of the server run in it.
+func (m *ExecStateManager) poll(interval time.Duration, check func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		check()
+		select {
+		case <-ticker.C:
+		case <-m.mgrShutdownCh:
+			return
+		}
+	}
 }
 
 func (m *ExecStateManager) getRegisteredProcesses() []int {
@@ -91,18 +227,34 @@ func (m *ExecStateManager) getRegisteredProcesses() []int {
 	return pids
 }
 
-func (m *ExecStateManager) registerProcess(pid int) {
+// getRegistrations returns the registered processes by PID
+func (m *ExecStateManager) getRegistrations() []Registration {
+	m.processesMu.Lock()
+	defer m.processesMu.Unlock()
+
+	return slices.SortedFunc(maps.Values(m.processes), func(a, b Registration) int {
+		return cmp.Compare(a.Process, b.Process)
+	})
+}
+
+// registerProcess keeps the time of the first registration of a process
+func (m *ExecStateManager) registerProcess(pid int, client string) {
 	m.processesMu.Lock()
 	defer m.processesMu.Unlock()
 
-	m.processes[pid] = struct{}{}
+	if _, ok := m.processes[pid]; !ok {
+		m.processes[pid] = Registration{Process: pid, Client: client, Registered: m.now()}
+	}
 }
 
-func (m *ExecStateManager) unregisterProcess(pid int) {
+// unregisterProcess returns false if the process was not registered
+func (m *ExecStateManager) unregisterProcess(pid int) bool {
 	m.processesMu.Lock()
 	defer m.processesMu.Unlock()
 
+	_, ok := m.processes[pid]
 	delete(m.processes, pid)
+	return ok
 }
 
 func (m *ExecStateManager) hasRegisteredProcesses() bool {
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// serveMetrics reports counters in the Prometheus text exposition format.
+func (m *ExecStateManager) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	h := m.health()
+
+	m.backend.mu.Lock()
+	calls, failures := m.backend.calls, m.backend.failuresTotal
+	m.backend.mu.Unlock()
+
+	var b strings.Builder
+	metric := func(name, kind, help string, value any) {
+		fmt.Fprintf(&b, "# HELP nosleep_%s %s\n# TYPE nosleep_%s %s\nnosleep_%s %v\n", name, help, name, kind, name, value)
+	}
+	metric("state_flags", "gauge", "Execution state flags last applied.", m.appliedFlags())
+	metric("backend_calls_total", "counter", "Calls to the backend.", calls)
+	metric("backend_failures_total", "counter", "Failed calls to the backend.", failures)
+	metric("reassertions_total", "counter", "Periodic re-assertions of the state.", h.Reassertions)
+	metric("drift_total", "counter", "Times the backend was found in another state than the one applied.", h.Drifts)
+	metric("degraded", "gauge", "Whether the server is degraded by backend failures.", boolMetric(h.Status == HealthDegraded))
+	metric("ready", "gauge", "Whether the server is ready.", boolMetric(h.Ready))
+	metric("uptime_seconds", "gauge", "Time since the server started.", h.Uptime.Seconds())
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprint(w, b.String())
+}
+
+func boolMetric(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
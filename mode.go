@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// modes maps the mode names used on the command line and in RPC requests
+// to the execution state flags they set (ES_CONTINUOUS is always added).
+var modes = map[string]uint32{
+	"clear":    0,
+	"system":   ES_SYSTEM_REQUIRED,
+	"display":  ES_SYSTEM_REQUIRED | ES_DISPLAY_REQUIRED,
+	"critical": ES_SYSTEM_REQUIRED | ES_AWAYMODE_REQUIRED,
+}
+
+// parseMode returns the flags for a mode name (case insensitive).
+func parseMode(name string) (uint32, error) {
+	flags, ok := modes[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown mode %q (expected system, display, critical or clear)", name)
+	}
+	return flags, nil
+}
+
+// modeName returns the name of the strongest mode contained in flags.
+func modeName(flags uint32) string {
+	switch {
+	case flags&ES_DISPLAY_REQUIRED != 0:
+		return "display"
+	case flags&ES_AWAYMODE_REQUIRED != 0:
+		return "critical"
+	case flags&ES_SYSTEM_REQUIRED != 0:
+		return "system"
+	default:
+		return "clear"
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ifCounters holds the cumulative byte counters of a network interface.
+type ifCounters struct {
+	rx uint64
+	tx uint64
+}
+
+// A networkSource returns the byte counters of the network interfaces by name.
+// Loopback interfaces are left out.
+type networkSource interface {
+	counters() (map[string]ifCounters, error)
+}
+
+// sysfsNetworkSource reads the counters from <root>/<interface>/statistics,
+// where root is /sys/class/net unless --net-root is given.
+type sysfsNetworkSource struct {
+	root string
+}
+
+func (s sysfsNetworkSource) counters() (map[string]ifCounters, error) {
+	entries, err := os.ReadDir(s.root)
+	if err != nil {
+		return nil, err
+	}
+
+	counters := make(map[string]ifCounters)
+	for _, entry := range entries {
+		name := entry.Name()
+		if name == "lo" {
+			continue
+		}
+		rx, err := readCounter(filepath.Join(s.root, name, "statistics", "rx_bytes"))
+		if err != nil {
+			return nil, err
+		}
+		tx, err := readCounter(filepath.Join(s.root, name, "statistics", "tx_bytes"))
+		if err != nil {
+			return nil, err
+		}
+		counters[name] = ifCounters{rx: rx, tx: tx}
+	}
+	return counters, nil
+}
+
+func readCounter(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// networkCondition is met while the traffic of the watched interfaces (all
+// of them if none are configured) exceeds the threshold.
+type networkCondition struct {
+	source     networkSource
+	threshold  float64 // bytes per second, received and sent
+	interfaces []string
+
+	previous     map[string]ifCounters
+	previousTime time.Time
+}
+
+func (n *networkCondition) check(now time.Time) (conditionResult, error) {
+	counters, err := n.source.counters()
+	if err != nil {
+		return conditionResult{}, err
+	}
+	previous, previousTime := n.previous, n.previousTime
+	n.previous, n.previousTime = counters, now
+	if previousTime.IsZero() {
+		return conditionResult{reason: "waiting for a second sample"}, nil
+	}
+	elapsed := now.Sub(previousTime).Seconds()
+	if elapsed <= 0 {
+		return conditionResult{reason: "waiting for a second sample"}, nil
+	}
+
+	result := conditionResult{readings: make(map[string]float64)}
+	var total, busiestRate float64
+	var busiest string
+	for name, c := range counters {
+		if len(n.interfaces) > 0 && !slices.Contains(n.interfaces, name) {
+			continue
+		}
+		p, ok := previous[name]
+		if !ok || c.rx < p.rx || c.tx < p.tx {
+			continue // new interface or counters were reset
+		}
+		rx := float64(c.rx-p.rx) / elapsed
+		tx := float64(c.tx-p.tx) / elapsed
+		result.readings[name+".rx_bytes_per_sec"] = rx
+		result.readings[name+".tx_bytes_per_sec"] = tx
+		total += rx + tx
+		if rx+tx > busiestRate {
+			busiest, busiestRate = name, rx+tx
+		}
+	}
+
+	result.met = total >= n.threshold
+	if result.met {
+		result.reason = fmt.Sprintf("network %.1f MB/s >= %.1f MB/s (busiest: %s)", total/1e6, n.threshold/1e6, busiest)
+	} else {
+		result.reason = "network traffic below threshold"
+	}
+	return result, nil
+}
@@ -0,0 +1,7 @@
+//go:build !windows
+
+package main
+
+func defaultNetworkSource() networkSource {
+	return sysfsNetworkSource{root: "/sys/class/net"}
+}
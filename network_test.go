@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// writeNetFixture writes the statistics of an interface in a fake /sys/class/net.
+func writeNetFixture(t *testing.T, root, name string, rx, tx uint64) {
+	t.Helper()
+	writeFixture(t, root, map[string]string{
+		name + "/statistics/rx_bytes": strconv.FormatUint(rx, 10) + "\n",
+		name + "/statistics/tx_bytes": strconv.FormatUint(tx, 10) + "\n",
+	})
+}
+
+func TestNetworkCondition(t *testing.T) {
+	root := t.TempDir()
+	network := &networkCondition{
+		source:    sysfsNetworkSource{root: root},
+		threshold: 1e6,
+	}
+	start := time.Now()
+
+	writeNetFixture(t, root, "lo", 0, 0)
+	writeNetFixture(t, root, "eth0", 0, 0)
+	writeNetFixture(t, root, "wlan0", 0, 0)
+	if result, err := network.check(start); err != nil || result.met {
+		t.Fatalf("first sample should not be met: %+v, %v", result, err)
+	}
+
+	// 0.5 MB/s on eth0, loopback traffic is ignored
+	writeNetFixture(t, root, "lo", 50e6, 50e6)
+	writeNetFixture(t, root, "eth0", 4e6, 1e6)
+	result, err := network.check(start.Add(10 * time.Second))
+	if err != nil || result.met {
+		t.Fatalf("expected traffic below threshold: %+v, %v", result, err)
+	}
+	if rx := result.readings["eth0.rx_bytes_per_sec"]; rx != 4e5 {
+		t.Errorf("expected eth0 to receive 400000 B/s, got %v", rx)
+	}
+	if _, ok := result.readings["lo.rx_bytes_per_sec"]; ok {
+		t.Error("loopback interface should not be reported")
+	}
+
+	// 1.5 MB/s across eth0 and wlan0
+	writeNetFixture(t, root, "eth0", 9e6, 1e6)
+	writeNetFixture(t, root, "wlan0", 0, 10e6)
+	if result, _ = network.check(start.Add(20 * time.Second)); !result.met {
+		t.Errorf("expected network traffic to be met: %+v", result)
+	}
+
+	// only watching eth0
+	network.interfaces = []string{"eth0"}
+	writeNetFixture(t, root, "eth0", 9e6, 1e6)
+	writeNetFixture(t, root, "wlan0", 0, 50e6)
+	if result, _ = network.check(start.Add(30 * time.Second)); result.met {
+		t.Errorf("expected traffic of unwatched interfaces to be ignored: %+v", result)
+	}
+}
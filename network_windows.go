@@ -0,0 +1,38 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// windowsNetworkSource reads the 64-bit counters of the interface table, by
+// interface description. The 32-bit counters of GetIfTable wrap in less than
+// a minute on a gigabit link. Filter interfaces are left out, they count the
+// traffic of their adapter again.
+type windowsNetworkSource struct{}
+
+func (windowsNetworkSource) counters() (map[string]ifCounters, error) {
+	rows, err := GetIfTable2()
+	if err != nil {
+		return nil, err
+	}
+
+	counters := make(map[string]ifCounters)
+	for _, row := range rows {
+		if row.Type == IF_TYPE_SOFTWARE_LOOPBACK || row.InterfaceAndOperStatusFlags&IF_FLAG_FILTER_INTERFACE != 0 {
+			continue
+		}
+		name := syscall.UTF16ToString(row.Description[:])
+		if _, exists := counters[name]; exists {
+			name = fmt.Sprintf("%s #%d", name, row.InterfaceIndex)
+		}
+		counters[name] = ifCounters{rx: row.InOctets, tx: row.OutOctets}
+	}
+	return counters, nil
+}
+
+func defaultNetworkSource() networkSource {
+	return windowsNetworkSource{}
+}
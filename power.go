@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PowerStatus reports the power source and the policy decision in Read.
+// Percent is -1 when there is no battery or its charge is unknown.
+type PowerStatus struct {
+	HasBattery bool
+	OnBattery  bool
+	Percent    int
+	Policy     string
+}
+
+// A powerSource returns the current power status (without policy).
+type powerSource interface {
+	status() (PowerStatus, error)
+}
+
+// sysfsPowerSource reads the power supplies from the directories of root, one
+// per supply with type, online, capacity and status attributes. Root is
+// /sys/class/power_supply unless --power-root is given.
+type sysfsPowerSource struct {
+	root string
+}
+
+func (s sysfsPowerSource) status() (PowerStatus, error) {
+	status := PowerStatus{Percent: -1}
+	entries, err := os.ReadDir(s.root)
+	if err != nil {
+		return status, err
+	}
+
+	read := func(supply, attr string) string {
+		data, _ := os.ReadFile(filepath.Join(s.root, supply, attr))
+		return strings.TrimSpace(string(data))
+	}
+
+	hasMains, mainsOnline, discharging := false, false, false
+	total, batteries := 0, 0
+	for _, entry := range entries {
+		switch read(entry.Name(), "type") {
+		case "Mains":
+			hasMains = true
+			mainsOnline = mainsOnline || read(entry.Name(), "online") == "1"
+		case "Battery":
+			if read(entry.Name(), "present") == "0" {
+				continue
+			}
+			status.HasBattery = true
+			discharging = discharging || read(entry.Name(), "status") == "Discharging"
+			if capacity, err := strconv.Atoi(read(entry.Name(), "capacity")); err == nil {
+				total += capacity
+				batteries++
+			}
+		}
+	}
+	if batteries > 0 {
+		status.Percent = total / batteries
+	}
+	if status.HasBattery {
+		// without a mains supply, rely on the battery status
+		status.OnBattery = (hasMains && !mainsOnline) || (!hasMains && discharging)
+	}
+	return status, nil
+}
+
+// powerPolicy adjusts the effective state to the power source, so that a
+// laptop is not kept awake on battery longer than it should.
+type powerPolicy struct {
+	source       powerSource
+	downgrade    bool // downgrade Display to System on battery
+	releaseBelow int  // release everything on battery below this charge (percent)
+
+	mu     sync.Mutex
+	status PowerStatus
+}
+
+// filter returns the flags allowed by the policy for a power status, and
+// the decision that was taken ("" if none applies).
+func (p *powerPolicy) filter(s PowerStatus, flags uint32) (uint32, string) {
+	switch {
+	case !s.OnBattery:
+		return flags, ""
+	case p.releaseBelow > 0 && s.Percent >= 0 && s.Percent < p.releaseBelow:
+		return 0, fmt.Sprintf("all holds released, battery at %d%% < %d%%", s.Percent, p.releaseBelow)
+	case p.downgrade:
+		return flags &^ ES_DISPLAY_REQUIRED, "display downgraded to system on battery"
+	}
+	return flags, ""
+}
+
+// apply filters the flags according to the last power status.
+func (p *powerPolicy) apply(flags uint32) uint32 {
+	if p == nil {
+		return flags
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	flags, _ = p.filter(p.status, flags)
+	return flags
+}
+
+// update reads the power source and reports whether the policy decision changed.
+func (p *powerPolicy) update() (bool, error) {
+	status, err := p.source.status()
+	if err != nil {
+		return false, err
+	}
+	_, status.Policy = p.filter(status, 0)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	changed := status.Policy != p.status.Policy
+	if changed {
+		if status.Policy != "" {
+			log.Printf("Power policy — %s", status.Policy)
+		} else {
+			log.Printf("Power policy — on AC power or policy lifted, battery at %d%%", status.Percent)
+		}
+	}
+	p.status = status
+	return changed, nil
+}
+
+func (p *powerPolicy) getStatus() *PowerStatus {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	status := p.status
+	return &status
+}
+
+// checkPower updates the power status and re-applies the state if the policy changed.
+func (m *ExecStateManager) checkPower() {
+	changed, err := m.power.update()
+	if err != nil {
+		log.Printf("Power status error: %v", err)
+		return
+	}
+	if changed {
+		if err := m.refreshState(); err != nil {
+			log.Printf("Power policy — failed to apply state: %v", err)
+		}
+	}
+}
+
+// runPowerPolicy checks the power status periodically until the manager stops.
+func (m *ExecStateManager) runPowerPolicy(interval time.Duration) {
+	if m.power == nil {
+		return
+	}
+	m.poll(interval, m.checkPower)
+}
@@ -0,0 +1,7 @@
+//go:build !windows
+
+package main
+
+func defaultPowerSource() powerSource {
+	return sysfsPowerSource{root: "/sys/class/power_supply"}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writePowerFixture writes a mains supply and a battery in a fake /sys/class/power_supply.
+func writePowerFixture(t *testing.T, root, online, capacity, status string) {
+	t.Helper()
+	writeFixture(t, root, map[string]string{
+		"AC/type":            "Mains\n",
+		"AC/online":          online + "\n",
+		"BAT0/type":          "Battery\n",
+		"BAT0/present":       "1\n",
+		"BAT0/capacity":      capacity + "\n",
+		"BAT0/status":        status + "\n",
+		"ucsi-source/type":   "USB\n",
+		"ucsi-source/online": "0\n",
+	})
+}
+
+func TestSysfsPowerSource(t *testing.T) {
+	root := t.TempDir()
+	source := sysfsPowerSource{root: root}
+
+	writePowerFixture(t, root, "1", "80", "Charging")
+	status, err := source.status()
+	if err != nil || !status.HasBattery || status.OnBattery || status.Percent != 80 {
+		t.Errorf("expected AC power with battery at 80%%, got %+v, %v", status, err)
+	}
+
+	writePowerFixture(t, root, "0", "42", "Discharging")
+	status, err = source.status()
+	if err != nil || !status.OnBattery || status.Percent != 42 {
+		t.Errorf("expected battery power at 42%%, got %+v, %v", status, err)
+	}
+
+	// desktop without battery
+	os.RemoveAll(filepath.Join(root, "BAT0"))
+	status, err = source.status()
+	if err != nil || status.HasBattery || status.OnBattery || status.Percent != -1 {
+		t.Errorf("expected no battery, got %+v, %v", status, err)
+	}
+}
+
+func TestPowerPolicy(t *testing.T) {
+	root := t.TempDir()
+	writePowerFixture(t, root, "1", "80", "Charging")
+
+	manager := &ExecStateManager{
+		power: &powerPolicy{source: sysfsPowerSource{root: root}, downgrade: true, releaseBelow: 15},
+	}
+	manager.Start()
+	defer manager.Stop()
+
+	if err := manager.Display(ExecStateRequest{}, &ExecStateReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.hold("test", ES_SYSTEM_REQUIRED); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		online, capacity, status string
+		want                     uint32
+		policy                   string
+	}{
+		{"1", "80", "Charging", ES_SYSTEM_REQUIRED | ES_DISPLAY_REQUIRED, ""},
+		{"0", "50", "Discharging", ES_SYSTEM_REQUIRED, "display downgraded to system on battery"},
+		{"0", "10", "Discharging", 0, "all holds released, battery at 10% < 15%"},
+		{"1", "11", "Charging", ES_SYSTEM_REQUIRED | ES_DISPLAY_REQUIRED, ""},
+	}
+	for _, step := range steps {
+		writePowerFixture(t, root, step.online, step.capacity, step.status)
+		manager.checkPower()
+
+		if manager.applied != step.want {
+			t.Errorf("on %s at %s%%: expected flags 0x%X, got 0x%X", step.status, step.capacity, step.want, manager.applied)
+		}
+		var reply ExecStateReply
+		if err := manager.Read(ExecStateRequest{}, &reply); err != nil {
+			t.Fatal(err)
+		}
+		if reply.Power == nil || reply.Power.Policy != step.policy {
+			t.Errorf("on %s at %s%%: expected policy %q, got %+v", step.status, step.capacity, step.policy, reply.Power)
+		}
+	}
+}
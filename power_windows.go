@@ -0,0 +1,26 @@
+//go:build windows
+
+package main
+
+// windowsPowerSource reads the power status with GetSystemPowerStatus.
+type windowsPowerSource struct{}
+
+func (windowsPowerSource) status() (PowerStatus, error) {
+	ps, err := GetSystemPowerStatus()
+	if err != nil {
+		return PowerStatus{Percent: -1}, err
+	}
+	status := PowerStatus{
+		HasBattery: ps.BatteryFlag != 128 && ps.BatteryFlag != 255,
+		OnBattery:  ps.ACLineStatus == 0,
+		Percent:    -1,
+	}
+	if ps.BatteryLifePercent != 255 {
+		status.Percent = int(ps.BatteryLifePercent)
+	}
+	return status, nil
+}
+
+func defaultPowerSource() powerSource {
+	return windowsPowerSource{}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// processInfo describes a running process. Path is empty when it cannot be
+// read, argv0 is the program name of the command line where available.
+type processInfo struct {
+	pid   int
+	name  string
+	path  string
+	argv0 string
+}
+
+// A processSource lists the running processes.
+type processSource interface {
+	processes() ([]processInfo, error)
+}
+
+// procProcessSource reads the processes from the numbered directories of root
+// (/proc on Linux): the command name from comm, the executable from the exe
+// link (only readable for our own processes) and argv[0] from cmdline.
+type procProcessSource struct {
+	root string
+}
+
+func (p procProcessSource) processes() ([]processInfo, error) {
+	entries, err := os.ReadDir(p.root)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []processInfo
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		comm, err := os.ReadFile(filepath.Join(p.root, entry.Name(), "comm"))
+		if err != nil {
+			continue // process exited in the meantime
+		}
+		path, _ := os.Readlink(filepath.Join(p.root, entry.Name(), "exe"))
+		cmdline, _ := os.ReadFile(filepath.Join(p.root, entry.Name(), "cmdline"))
+		argv0, _, _ := strings.Cut(string(cmdline), "\x00")
+		list = append(list, processInfo{pid: pid, name: strings.TrimSpace(string(comm)), path: path, argv0: argv0})
+	}
+	return list, nil
+}
+
+// How long a process list is reused, so that rules checked in the same round
+// share a single scan.
+const processScanTTL = time.Second
+
+// processScanner caches the process list of a source.
+type processScanner struct {
+	source processSource
+
+	mu      sync.Mutex
+	scanned time.Time
+	list    []processInfo
+	err     error
+}
+
+func (s *processScanner) processes(now time.Time) ([]processInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.scanned.IsZero() || now.Sub(s.scanned) >= processScanTTL || now.Before(s.scanned) {
+		s.list, s.err = s.source.processes()
+		s.scanned = now
+	}
+	return s.list, s.err
+}
+
+// ProcessMatch is a running process that matched a watch rule.
+type ProcessMatch struct {
+	PID    int
+	Name   string
+	Reason string
+}
+
+// processCondition is met while a process matches the rule. Rules containing
+// a path separator are matched against the executable path, others against
+// the process name and the base names of the executable and argv[0], with or
+// without extension: the kernel truncates the name in comm to 15 characters.
+// Both may use glob patterns and are case insensitive.
+type processCondition struct {
+	scanner *processScanner
+	rule    string
+
+	mu      sync.Mutex
+	matches []ProcessMatch
+}
+
+// parseWatchRule splits "pattern[=mode]" into the pattern and the mode flags.
+func parseWatchRule(s string) (string, uint32, error) {
+	pattern, mode, found := strings.Cut(s, "=")
+	if !found {
+		mode = "system"
+	}
+	flags, err := parseMode(mode)
+	if err != nil {
+		return "", 0, err
+	}
+	if flags == 0 {
+		return "", 0, fmt.Errorf("watch rule %q must keep the computer awake", s)
+	}
+	if _, err := filepath.Match(strings.ToLower(pattern), ""); err != nil || pattern == "" {
+		return "", 0, fmt.Errorf("invalid watch pattern %q", pattern)
+	}
+	return pattern, flags, nil
+}
+
+func (p *processCondition) matchesRule(proc processInfo) bool {
+	pattern := strings.ToLower(p.rule)
+	if strings.ContainsAny(pattern, `/\`) {
+		ok, _ := filepath.Match(filepath.Clean(pattern), strings.ToLower(filepath.Clean(proc.path)))
+		return ok && proc.path != ""
+	}
+	for _, name := range []string{proc.name, baseName(proc.path), baseName(proc.argv0)} {
+		name = strings.ToLower(name)
+		if name == "" {
+			continue
+		}
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, strings.TrimSuffix(name, filepath.Ext(name))); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// baseName returns the last element of a Linux or Windows path, or "".
+func baseName(path string) string {
+	if i := strings.LastIndexAny(path, `/\`); i >= 0 {
+		path = path[i+1:]
+	}
+	return path
+}
+
+func (p *processCondition) check(now time.Time) (conditionResult, error) {
+	list, err := p.scanner.processes(now)
+	if err != nil {
+		return conditionResult{}, err
+	}
+
+	var matches []ProcessMatch
+	for _, proc := range list {
+		if p.matchesRule(proc) {
+			matches = append(matches, ProcessMatch{
+				PID:    proc.pid,
+				Name:   proc.name,
+				Reason: fmt.Sprintf("matched rule %s", p.rule),
+			})
+		}
+	}
+
+	p.mu.Lock()
+	p.matches = matches
+	p.mu.Unlock()
+
+	result := conditionResult{
+		met:      len(matches) > 0,
+		readings: map[string]float64{"matches": float64(len(matches))},
+	}
+	if result.met {
+		result.reason = fmt.Sprintf("%d process(es) matched rule %s", len(matches), p.rule)
+	} else {
+		result.reason = "no process matched rule " + p.rule
+	}
+	return result, nil
+}
+
+func (p *processCondition) getMatches() []ProcessMatch {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]ProcessMatch(nil), p.matches...)
+}
+
+// getProcessMatches returns the processes matched by all watch rules.
+func (m *ExecStateManager) getProcessMatches() []ProcessMatch {
+	var matches []ProcessMatch
+	for _, c := range m.conditions {
+		if p, ok := c.cond.(*processCondition); ok {
+			matches = append(matches, p.getMatches()...)
+		}
+	}
+	return matches
+}
@@ -0,0 +1,7 @@
+//go:build !windows
+
+package main
+
+func defaultProcessSource() processSource {
+	return procProcessSource{root: "/proc"}
+}
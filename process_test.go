@@ -0,0 +1,114 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeProcessFixture adds a process to a fake /proc.
+func writeProcessFixture(t *testing.T, root string, pid, name, exe string) {
+	t.Helper()
+	writeFixture(t, root, map[string]string{pid + "/comm": name + "\n"})
+	if exe != "" {
+		if err := os.Symlink(exe, filepath.Join(root, pid, "exe")); err != nil {
+			t.Skipf("cannot create symlinks: %v", err)
+		}
+	}
+}
+
+func TestParseWatchRule(t *testing.T) {
+	testCases := []struct {
+		rule    string
+		pattern string
+		flags   uint32
+		wantErr bool
+	}{
+		{"ffmpeg", "ffmpeg", ES_SYSTEM_REQUIRED, false},
+		{"robocopy.exe=display", "robocopy.exe", ES_SYSTEM_REQUIRED | ES_DISPLAY_REQUIRED, false},
+		{"python*=critical", "python*", ES_SYSTEM_REQUIRED | ES_AWAYMODE_REQUIRED, false},
+		{"ffmpeg=clear", "", 0, true},
+		{"ffmpeg=turbo", "", 0, true},
+		{"=system", "", 0, true},
+		{"[ffmpeg", "", 0, true},
+	}
+
+	for _, tc := range testCases {
+		pattern, flags, err := parseWatchRule(tc.rule)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("parseWatchRule(%q): expected an error", tc.rule)
+			}
+			continue
+		}
+		if err != nil || pattern != tc.pattern || flags != tc.flags {
+			t.Errorf("parseWatchRule(%q) = (%q, 0x%X, %v), want (%q, 0x%X)", tc.rule, pattern, flags, err, tc.pattern, tc.flags)
+		}
+	}
+}
+
+func TestProcessCondition(t *testing.T) {
+	root := t.TempDir()
+	writeProcessFixture(t, root, "1", "systemd", "/usr/lib/systemd/systemd")
+	writeProcessFixture(t, root, "42", "ffmpeg", "/usr/bin/ffmpeg")
+	writeProcessFixture(t, root, "43", "Robocopy.exe", "")
+	writeProcessFixture(t, root, "self", "ignored", "")
+	// comm is truncated to 15 characters, the exe link of other users cannot be read
+	writeProcessFixture(t, root, "44", "chromium-browse", "")
+	writeFixture(t, root, map[string]string{"44/cmdline": "/usr/lib/chromium/chromium-browser\x00--type=renderer\x00"})
+	writeProcessFixture(t, root, "45", "gsd-power-manag", "/usr/libexec/gsd-power-manager")
+
+	manager := &ExecStateManager{}
+	manager.Start()
+	defer manager.Stop()
+
+	scanner := &processScanner{source: procProcessSource{root: root}}
+	rules := []struct {
+		rule string
+		met  bool
+	}{
+		{"ffmpeg", true},
+		{"robocopy", true},
+		{"/usr/bin/*", true},
+		{"/opt/ffmpeg", false},
+		{"rsync", false},
+		{"chromium-browser", true},
+		{"gsd-power-manager", true},
+	}
+	for _, r := range rules {
+		manager.addCondition(newConditionMonitor("process:"+r.rule, &processCondition{scanner: scanner, rule: r.rule}, ES_SYSTEM_REQUIRED, 0))
+	}
+
+	for _, c := range manager.conditions {
+		c.evaluate(manager)
+	}
+	for i, status := range manager.getConditionStatus() {
+		if status.Holding != rules[i].met {
+			t.Errorf("rule %q: expected holding %v, got %v (%s)", rules[i].rule, rules[i].met, status.Holding, status.Reason)
+		}
+	}
+
+	var reply ExecStateReply
+	if err := manager.Read(ExecStateRequest{}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"matched rule ffmpeg": 42, "matched rule robocopy": 43, "matched rule /usr/bin/*": 42,
+		"matched rule chromium-browser": 44, "matched rule gsd-power-manager": 45}
+	if len(reply.Matches) != len(want) {
+		t.Fatalf("expected %d matches, got %v", len(want), reply.Matches)
+	}
+	for _, match := range reply.Matches {
+		if want[match.Reason] != match.PID {
+			t.Errorf("unexpected match %+v", match)
+		}
+	}
+
+	// the process exits
+	os.RemoveAll(filepath.Join(root, "42"))
+	scanner.scanned = time.Time{}
+	manager.conditions[0].evaluate(manager)
+	if manager.getConditionStatus()[0].Holding {
+		t.Error("expected the ffmpeg rule to release its hold once the process exited")
+	}
+}
@@ -0,0 +1,50 @@
+//go:build windows
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// windowsProcessSource lists the processes with a toolhelp snapshot. The path
+// is only available for processes that can be opened by the current user.
+type windowsProcessSource struct{}
+
+func (windowsProcessSource) processes() ([]processInfo, error) {
+	snapshot, err := syscall.CreateToolhelp32Snapshot(syscall.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.CloseHandle(snapshot) //nolint:errcheck
+
+	var entry syscall.ProcessEntry32
+	entry.Size = uint32(unsafe.Sizeof(entry))
+	if err := syscall.Process32First(snapshot, &entry); err != nil {
+		return nil, err
+	}
+
+	var list []processInfo
+	for {
+		proc := processInfo{
+			pid:  int(entry.ProcessID),
+			name: syscall.UTF16ToString(entry.ExeFile[:]),
+		}
+		if h, err := syscall.OpenProcess(PROCESS_QUERY_LIMITED_INFORMATION, false, entry.ProcessID); err == nil {
+			proc.path, _ = QueryFullProcessImageName(h)
+			syscall.CloseHandle(h) //nolint:errcheck
+		}
+		list = append(list, proc)
+
+		if err := syscall.Process32Next(snapshot, &entry); err != nil {
+			if err == syscall.ERROR_NO_MORE_FILES {
+				return list, nil
+			}
+			return nil, err
+		}
+	}
+}
+
+func defaultProcessSource() processSource {
+	return windowsProcessSource{}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+// assertState calls the backend with flags and checks that the state it reports
+// as previous is the one applied last. It must be called on the OS thread.
+//
+// On Windows the execution state belongs to the OS thread, so other processes
+// cannot change it: a drift there means that the backend lost or did not apply
+// the state, not that software reset it. Power settings changed behind our back
+// (sleep timeouts, power plans) are not seen.
+func (m *ExecStateManager) assertState(flags uint32, reassert bool) error {
+	flags |= ES_CONTINUOUS
+	ret, err := m.setThreadState(flags)
+	expected, drifted := m.backend.record(flags, ret, reassert, err, m.now())
+	if err != nil {
+		log.Printf("SetThreadExecutionState error: %v", err)
+		if !reassert {
+			atomic.StoreUint32(&m.previousState, 0)
+		}
+		return err
+	}
+	if !reassert {
+		// Please note that return value is the PREVIOUS state
+		atomic.StoreUint32(&m.previousState, ret)
+	}
+	if drifted {
+		detail := fmt.Sprintf("state was 0x%08X (%s), expected 0x%08X (%s)", ret, modeName(ret), expected, modeName(expected))
+		log.Printf("Drift — %s, re-asserted", detail)
+		m.publish(Event{Type: EventDrift, Flags: ret, Detail: detail})
+	}
+	return nil
+}
+
+// reassertState applies the flags last applied successfully again, also after
+// a failure left the backend in an unknown state. It must be called on the OS thread.
+func (m *ExecStateManager) reassertState() {
+	if flags, ok := m.backend.appliedFlags(); ok {
+		m.assertState(flags, true) //nolint:errcheck
+	}
+}
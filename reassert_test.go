@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReassert(t *testing.T) {
+	var state atomic.Uint32
+	state.Store(ES_CONTINUOUS)
+	manager := &ExecStateManager{
+		setState: func(flags uint32) (uint32, error) {
+			return state.Swap(flags), nil
+		},
+		reassertInterval: 5 * time.Millisecond,
+	}
+	manager.Start()
+	defer manager.Stop()
+
+	var reply ExecStateReply
+	if err := manager.Display(ExecStateRequest{}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "a re-assertion", func() bool { return manager.health().Reassertions > 0 })
+	if h := manager.health(); h.Drifts != 0 {
+		t.Fatalf("expected no drift, got %+v", h)
+	}
+
+	// another program resets the state
+	cursor := manager.events.cursor()
+	state.Store(ES_CONTINUOUS)
+	waitFor(t, "a drift", func() bool { return manager.health().Drifts == 1 })
+	if got := state.Load(); got != ES_CONTINUOUS|ES_SYSTEM_REQUIRED|ES_DISPLAY_REQUIRED {
+		t.Errorf("expected the state to be re-asserted, got 0x%08X", got)
+	}
+	if h := manager.health(); h.LastDrift.IsZero() {
+		t.Errorf("expected the time of the drift, got %+v", h)
+	}
+	events, _ := manager.events.since(cursor)
+	if len(events) != 1 || events[0].Type != EventDrift || events[0].Flags != ES_CONTINUOUS {
+		t.Errorf("expected a drift event, got %v", events)
+	}
+
+	// re-assertions do not change the previous state returned by Read
+	if err := manager.Read(ExecStateRequest{}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Flags != ES_CONTINUOUS || reply.Health == nil || reply.Health.Drifts != 1 {
+		t.Errorf("expected the state before Display and the drift in Read, got 0x%08X %+v", reply.Flags, reply.Health)
+	}
+
+	server := httptest.NewServer(manager.httpHandler())
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	for _, want := range []string{"nosleep_drift_total 1\n", "nosleep_state_flags 3\n", "nosleep_backend_failures_total 0\n"} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("expected %q in metrics, got:\n%s", want, body)
+		}
+	}
+}
+
+// waitFor polls cond until it is true, or fails the test after a second.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
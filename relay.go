@@ -0,0 +1,217 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/rpc"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long a call forwarded to an upstream may take, on top of the Watch wait.
+const defaultUpstreamTimeout = 10 * time.Second
+
+// Upstream is a nosleep-server the server relays calls to, given by
+// --upstream name=host:port.
+type Upstream struct {
+	Name    string
+	Network string
+	Address string
+	options dialOptions
+}
+
+// parseUpstream parses a --upstream value like "lab1=10.0.0.11:9001". The
+// address may be prefixed with a network like --listen, eg.
+// "local=unix:/run/nosleep.sock", and defaults to TCP. Options after "?"
+// connect to an upstream listening with the tls and token options of
+// --listen: tls-ca=FILE verifies its certificate, token=TOKEN or
+// token-file=PATH is sent to it, eg.
+// "lab1=10.0.0.11:9001?tls-ca=ca.pem&token-file=/etc/nosleep/token".
+func parseUpstream(s string) (Upstream, error) {
+	name, address, ok := strings.Cut(s, "=")
+	if !ok || name == "" || address == "" || name == "*" {
+		return Upstream{}, fmt.Errorf("invalid upstream %q (expected NAME=HOST:PORT)", s)
+	}
+	address, options, _ := strings.Cut(address, "?")
+	upstream := Upstream{Name: name, Network: "tcp", Address: address}
+	if network, addr, err := parseListen(address); err == nil {
+		upstream.Network, upstream.Address = network, addr
+	}
+	values, err := url.ParseQuery(options)
+	if err != nil {
+		return Upstream{}, fmt.Errorf("invalid options in upstream %q: %v", s, err)
+	}
+	for key, value := range values {
+		v := value[len(value)-1]
+		switch key {
+		case "tls-ca":
+			upstream.options.tlsCA = v
+			upstream.options.serverName, _, _ = net.SplitHostPort(upstream.Address)
+		case "token":
+			upstream.options.token = v
+		case "token-file":
+			if upstream.options.token, err = readTokenFile(v); err != nil {
+				return Upstream{}, err
+			}
+		default:
+			return Upstream{}, fmt.Errorf("unknown option %q in upstream %q (expected tls-ca, token or token-file)", key, s)
+		}
+	}
+	if (values.Has("token") || values.Has("token-file")) && upstream.options.token == "" {
+		return Upstream{}, fmt.Errorf("empty token in upstream %q", s)
+	}
+	return upstream, nil
+}
+
+// UpstreamResult is the outcome of a call forwarded to an upstream.
+type UpstreamResult struct {
+	Name    string
+	Address string
+	Reply   *ExecStateReply // nil if the call failed
+	Error   string          // prefixed with its code, see ErrorCodeOf
+	Elapsed time.Duration
+}
+
+// call dials the upstream and calls method, within timeout. The connection is
+// closed after the call: the upstream sees one connection per forwarded call.
+func (u Upstream) call(method string, req ExecStateRequest, timeout time.Duration) (*ExecStateReply, error) {
+	conn, err := u.options.dial(u.Network, u.Address, timeout)
+	if err != nil {
+		return nil, withCode(ErrUnavailable, err)
+	}
+	client := rpc.NewClient(conn)
+	defer client.Close() //nolint:errcheck
+
+	var reply ExecStateReply
+	if err := client.Call("ExecStateManager."+method, req, &reply); err != nil {
+		var serverErr rpc.ServerError
+		if errors.As(err, &serverErr) {
+			return nil, err
+		}
+		return nil, withCode(ErrUnavailable, err)
+	}
+	return &reply, nil
+}
+
+// forward calls method on the upstreams concurrently, and returns their
+// results in the order of the upstreams.
+func forward(upstreams []Upstream, method string, req ExecStateRequest, timeout time.Duration) []UpstreamResult {
+	results := make([]UpstreamResult, len(upstreams))
+	var wg sync.WaitGroup
+	for i, u := range upstreams {
+		wg.Go(func() {
+			start := time.Now()
+			reply, err := u.call(method, req, timeout)
+			results[i] = UpstreamResult{Name: u.Name, Address: u.Address, Reply: reply, Elapsed: time.Since(start)}
+			if err != nil {
+				results[i].Error = err.Error()
+			}
+		})
+	}
+	wg.Wait()
+	return results
+}
+
+// healthRank orders the health statuses from best to worst.
+var healthRank = map[string]int{HealthOK: 0, HealthDegraded: 1, HealthStopped: 2}
+
+// aggregateRead fills reply with the combined state of the upstreams of a
+// forwarded Read: the flags of all of them, their registrations with the
+// upstream name, and the health of the worst one. An upstream that did not
+// answer counts as stopped.
+func aggregateRead(results []UpstreamResult, reply *ExecStateReply) {
+	var worst *Health
+	for _, r := range results {
+		health := &Health{Status: HealthStopped, LastError: r.Error}
+		if r.Reply != nil {
+			reply.Flags |= r.Reply.Flags
+			reply.Applied |= r.Reply.Applied
+			for _, reg := range r.Reply.Registrations {
+				reg.Upstream = r.Name
+				reply.Registrations = append(reply.Registrations, reg)
+			}
+			if r.Reply.Health != nil {
+				health = r.Reply.Health
+			}
+		}
+		if worst == nil || healthRank[health.Status] > healthRank[worst.Status] {
+			worst = health
+		}
+	}
+	if worst != nil {
+		h := *worst
+		reply.Health = &h
+	}
+}
+
+// selectUpstreams returns the upstreams named in a comma separated list, or
+// all of them for "" or "*".
+func (m *ExecStateManager) selectUpstreams(names string) ([]Upstream, error) {
+	if names == "" || names == "*" {
+		return m.upstreams, nil
+	}
+	var selected []Upstream
+	for name := range strings.SplitSeq(names, ",") {
+		i := slices.IndexFunc(m.upstreams, func(u Upstream) bool { return u.Name == name })
+		if i < 0 {
+			return nil, newError(ErrNotFound, "no upstream named %q", name)
+		}
+		selected = append(selected, m.upstreams[i])
+	}
+	return selected, nil
+}
+
+// Forwards the call req.Method to the upstreams named in req.Upstream (comma
+// separated, all of them if empty or "*"), and returns the reply or error of
+// each one in reply.Upstreams. A forwarded Read also returns the combined
+// state in the fields of reply, see aggregateRead. The call itself only fails
+// if it cannot be forwarded: the errors of the upstreams are in their results.
+func (m *ExecStateManager) Forward(req ExecStateRequest, reply *ExecStateReply) error {
+	var err error
+	var upstreams []Upstream
+	switch req.Method {
+	case "Forward", "Hold":
+		// Hold would be bound to the connection of the relay, closed after the call
+		err = newError(ErrInvalid, "%s cannot be forwarded", req.Method)
+	case "":
+		err = newError(ErrInvalid, "no method to forward")
+	default:
+		if len(m.upstreams) == 0 {
+			err = newError(ErrUnsupported, "no upstream configured")
+		} else {
+			upstreams, err = m.selectUpstreams(req.Upstream)
+		}
+	}
+	if err != nil {
+		log.Printf("ExecStateManager.Forward — Failed to forward %s: %v", req.Method, err)
+		m.auditCall("Forward", req, req.Method, err)
+		return err
+	}
+
+	forwarded := req
+	forwarded.Method, forwarded.Upstream = "", ""
+	reply.Upstreams = forward(upstreams, req.Method, forwarded, defaultUpstreamTimeout+req.Wait)
+	if req.Method == "Read" {
+		aggregateRead(reply.Upstreams, reply)
+	}
+
+	var failed []string
+	for _, r := range reply.Upstreams {
+		if r.Error != "" {
+			failed = append(failed, r.Name)
+		}
+	}
+	log.Printf("ExecStateManager.Forward — %s forwarded to %d upstream(s), %d failed", req.Method, len(upstreams), len(failed))
+	detail := req.Method + " to " + cmp.Or(req.Upstream, "*")
+	if len(failed) > 0 {
+		detail += " (failed: " + strings.Join(failed, ",") + ")"
+	}
+	m.auditCall("Forward", req, detail, nil)
+	return nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+// serveUpstream serves the RPC methods of a started manager on a random port
+// with its own rpc.Server, so that several servers can run in a test, and
+// returns the address.
+func serveUpstream(t *testing.T, manager *ExecStateManager) string {
+	t.Helper()
+	return serveUpstreamOn(t, manager, "tcp:127.0.0.1:0")
+}
+
+// serveUpstreamOn is serveUpstream on a --listen value with its options.
+func serveUpstreamOn(t *testing.T, manager *ExecStateManager, spec string) string {
+	t.Helper()
+	server := rpc.NewServer()
+	if err := server.Register(manager); err != nil {
+		t.Fatalf("rpc.Register failed: %v", err)
+	}
+	listeners, err := listenAll([]string{spec})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	listener := listeners[0]
+	t.Cleanup(func() { listener.Close() })
+	manager.listener = listener
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.ServeConn(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestParseUpstream(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    Upstream
+		wantErr bool
+	}{
+		{"lab1=10.0.0.11:9001", Upstream{Name: "lab1", Network: "tcp", Address: "10.0.0.11:9001"}, false},
+		{"local=unix:/run/nosleep.sock", Upstream{Name: "local", Network: "unix", Address: "/run/nosleep.sock"}, false},
+		{"v6=tcp6:[::1]:9001", Upstream{Name: "v6", Network: "tcp6", Address: "[::1]:9001"}, false},
+		{"10.0.0.11:9001", Upstream{}, true},
+		{"*=10.0.0.11:9001", Upstream{}, true},
+		{"lab1=", Upstream{}, true},
+		{"lab1=10.0.0.11:9001?tls-ca=ca.pem&token=abc", Upstream{Name: "lab1", Network: "tcp", Address: "10.0.0.11:9001", options: dialOptions{tlsCA: "ca.pem", serverName: "10.0.0.11", token: "abc"}}, false},
+		{"local=unix:/run/nosleep.sock?token=abc", Upstream{Name: "local", Network: "unix", Address: "/run/nosleep.sock", options: dialOptions{token: "abc"}}, false},
+		{"lab1=10.0.0.11:9001?token=", Upstream{}, true},
+		{"lab1=10.0.0.11:9001?tls=cert.pem,key.pem", Upstream{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseUpstream(tt.spec)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseUpstream(%q) = %+v, %v", tt.spec, got, err)
+		}
+	}
+}
+
+func TestForwardSecure(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir)
+	manager := &ExecStateManager{}
+	manager.Start()
+	defer manager.Stop()
+	address := serveUpstreamOn(t, manager, "tcp:127.0.0.1:0?tls="+certFile+","+keyFile+"&token=s3cret")
+
+	tests := []struct {
+		options string
+		want    ErrorCode
+	}{
+		{"?tls-ca=" + certFile + "&token=s3cret", ""},
+		{"?tls-ca=" + certFile + "&token=guess", ErrDenied},
+		{"", ErrUnavailable},
+	}
+	for _, tt := range tests {
+		upstream, err := parseUpstream("lab1=" + address + tt.options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := forward([]Upstream{upstream}, "Ping", ExecStateRequest{}, time.Second)[0]
+		var got ErrorCode
+		if r.Error != "" {
+			got = ErrorCodeOf(rpc.ServerError(r.Error))
+		}
+		if got != tt.want {
+			t.Errorf("options %q: expected code %q, got %q (%s)", tt.options, tt.want, got, r.Error)
+		}
+	}
+}
+
+func TestForward(t *testing.T) {
+	var upstreams []Upstream
+	var managers []*ExecStateManager
+	for _, name := range []string{"lab1", "lab2"} {
+		manager := &ExecStateManager{keepOnEmpty: true}
+		manager.Start()
+		defer manager.Stop()
+		upstreams = append(upstreams, Upstream{Name: name, Network: "tcp", Address: serveUpstream(t, manager)})
+		managers = append(managers, manager)
+	}
+	// nothing listens on this one
+	unreachable, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable.Close()
+	upstreams = append(upstreams, Upstream{Name: "down", Network: "tcp", Address: unreachable.Addr().String()})
+
+	relay := &ExecStateManager{upstreams: upstreams}
+	relay.Start()
+	defer relay.Stop()
+
+	// keep the lab awake with one call
+	var reply ExecStateReply
+	if err := relay.Forward(ExecStateRequest{Method: "Display", Upstream: "lab1,lab2"}, &reply); err != nil {
+		t.Fatalf("Forward failed: %v", err)
+	}
+	if len(reply.Upstreams) != 2 || reply.Upstreams[0].Error != "" || reply.Upstreams[1].Error != "" {
+		t.Fatalf("expected 2 successful results, got %+v", reply.Upstreams)
+	}
+	for i, manager := range managers {
+		if flags := manager.appliedFlags(); flags != ES_SYSTEM_REQUIRED|ES_DISPLAY_REQUIRED {
+			t.Errorf("expected the display mode on %s, got %#x", upstreams[i].Name, flags)
+		}
+	}
+
+	// aggregated Read
+	reply = ExecStateReply{}
+	if err := relay.Forward(ExecStateRequest{Method: "Read"}, &reply); err != nil {
+		t.Fatalf("Forward failed: %v", err)
+	}
+	if len(reply.Upstreams) != 3 {
+		t.Fatalf("expected the results of all the upstreams, got %+v", reply.Upstreams)
+	}
+	for _, r := range reply.Upstreams[:2] {
+		if r.Reply == nil || r.Reply.Health == nil {
+			t.Errorf("expected the Read reply of %s, got %+v", r.Name, r)
+		}
+	}
+	if down := reply.Upstreams[2]; down.Reply != nil || ErrorCodeOf(rpc.ServerError(down.Error)) != ErrUnavailable {
+		t.Errorf("expected the unreachable upstream to fail, got %+v", down)
+	}
+
+	// upstream errors keep their code
+	reply = ExecStateReply{}
+	if err := relay.Forward(ExecStateRequest{Method: "Unregister", Upstream: "lab1", Process: 1234}, &reply); err != nil {
+		t.Fatalf("Forward failed: %v", err)
+	}
+	if len(reply.Upstreams) != 1 || ErrorCodeOf(rpc.ServerError(reply.Upstreams[0].Error)) != ErrNotFound {
+		t.Errorf("expected a not-found error from lab1, got %+v", reply.Upstreams)
+	}
+
+	for _, req := range []ExecStateRequest{{Method: "Hold"}, {Method: "Forward"}, {}, {Method: "Read", Upstream: "lab9"}} {
+		if err := relay.Forward(req, &ExecStateReply{}); err == nil {
+			t.Errorf("expected an error for %+v", req)
+		}
+	}
+}
+
+func TestForwardAggregate(t *testing.T) {
+	var upstreams []Upstream
+	var managers []*ExecStateManager
+	for _, name := range []string{"lab1", "lab2"} {
+		manager := &ExecStateManager{keepOnEmpty: true}
+		manager.Start()
+		defer manager.Stop()
+		upstreams = append(upstreams, Upstream{Name: name, Network: "tcp", Address: serveUpstream(t, manager)})
+		managers = append(managers, manager)
+	}
+	unreachable, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable.Close()
+	upstreams = append(upstreams, Upstream{Name: "down", Network: "tcp", Address: unreachable.Addr().String()})
+
+	relay := &ExecStateManager{upstreams: upstreams}
+	relay.Start()
+	defer relay.Stop()
+
+	// lab1 keeps the display on, lab2 the system for a registered process
+	if err := managers[0].Display(ExecStateRequest{}, &ExecStateReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := managers[1].System(ExecStateRequest{}, &ExecStateReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := managers[1].Register(ExecStateRequest{Process: 4242, Client: "backup"}, &ExecStateReply{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var reply ExecStateReply
+	if err := relay.Forward(ExecStateRequest{Method: "Read", Upstream: "lab1,lab2"}, &reply); err != nil {
+		t.Fatalf("Forward failed: %v", err)
+	}
+	if reply.Applied != ES_SYSTEM_REQUIRED|ES_DISPLAY_REQUIRED {
+		t.Errorf("expected the combined flags, got 0x%08X", reply.Applied)
+	}
+	if len(reply.Registrations) != 1 || reply.Registrations[0].Process != 4242 || reply.Registrations[0].Upstream != "lab2" {
+		t.Errorf("expected the registration of lab2, got %+v", reply.Registrations)
+	}
+	if reply.Health == nil || reply.Health.Status != HealthOK {
+		t.Errorf("expected healthy upstreams, got %+v", reply.Health)
+	}
+
+	// an upstream that does not answer is the worst
+	reply = ExecStateReply{}
+	if err := relay.Forward(ExecStateRequest{Method: "Read"}, &reply); err != nil {
+		t.Fatalf("Forward failed: %v", err)
+	}
+	if reply.Health == nil || reply.Health.Status != HealthStopped || reply.Health.LastError == "" {
+		t.Errorf("expected the unreachable upstream as health, got %+v", reply.Health)
+	}
+
+	// degraded is worse than ok, better than stopped
+	aggregated := ExecStateReply{}
+	aggregateRead([]UpstreamResult{
+		{Name: "a", Reply: &ExecStateReply{Health: &Health{Status: HealthOK}}},
+		{Name: "b", Reply: &ExecStateReply{Health: &Health{Status: HealthDegraded, Failures: 3}}},
+	}, &aggregated)
+	if aggregated.Health.Status != HealthDegraded || aggregated.Health.Failures != 3 {
+		t.Errorf("expected the degraded upstream as health, got %+v", aggregated.Health)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// reportRow is a line of the report output, in every format.
+type reportRow struct {
+	Kind    string  `json:"kind"` // mode or registrant
+	Name    string  `json:"name"`
+	Seconds float64 `json:"seconds"`
+	Hours   float64 `json:"hours"`
+}
+
+func reportRows(r *UsageReport) []reportRow {
+	var rows []reportRow
+	add := func(kind string, totals []UsageTotal) {
+		for _, t := range totals {
+			rows = append(rows, reportRow{Kind: kind, Name: t.Name, Seconds: t.Duration.Seconds(), Hours: t.Duration.Hours()})
+		}
+	}
+	add("mode", r.Modes)
+	add("registrant", r.Registrants)
+	return rows
+}
+
+// writeReport prints a report as a table, CSV or JSON.
+func writeReport(w io.Writer, r *UsageReport, format string) error {
+	rows := reportRows(r)
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			Since time.Time   `json:"since"`
+			Until time.Time   `json:"until"`
+			Rows  []reportRow `json:"totals"`
+		}{r.Since, r.Until, rows})
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"kind", "name", "seconds", "hours"}) //nolint:errcheck
+		for _, row := range rows {
+			cw.Write([]string{row.Kind, row.Name, //nolint:errcheck
+				strconv.FormatFloat(row.Seconds, 'f', 0, 64), strconv.FormatFloat(row.Hours, 'f', 2, 64)})
+		}
+		cw.Flush()
+		return cw.Error()
+	case "table":
+		fmt.Fprintf(w, "From %s to %s\n\n", r.Since.Local().Format(time.DateTime), r.Until.Local().Format(time.DateTime))
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "KIND\tNAME\tDURATION\tHOURS")
+		for _, row := range rows {
+			d := time.Duration(row.Seconds * float64(time.Second)).Round(time.Second)
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%.2f\n", row.Kind, row.Name, d, row.Hours)
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unknown format %q (expected table, csv or json)", format)
+}
+
+// reportCommand implements the "report" command, which asks the server for a
+// usage report, or computes it from a history file.
+func reportCommand(cfg *Config, args []string) int {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	since := fs.String("since", "", "start of the period (RFC 3339, YYYY-MM-DD or duration ago, default all history)")
+	until := fs.String("until", "", "end of the period (default now)")
+	format := fs.String("format", "table", "output format: table, csv or json")
+	file := fs.String("history", "", "read the history from this file instead of the server")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: "+name+" [-n network] [-a address] [-p port] report [OPTIONS]\n\nPrints the time spent in each mode and held awake by each registrant.\n\nOPTIONS:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	if *format != "table" && *format != "csv" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "unknown format %q (expected table, csv or json)\n", *format)
+		return 2
+	}
+
+	now := time.Now()
+	from, err := parseTimeArg(*since, now)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	to, err := parseTimeArg(*until, now)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	var report *UsageReport
+	if *file != "" {
+		intervals, err := readHistory(*file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		report = buildReport(intervals, from, to)
+	} else {
+		client, err := dialServer(cfg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer client.Close() //nolint:errcheck
+
+		var reply ExecStateReply
+		if err := client.Call("ExecStateManager.Report", ExecStateRequest{Since: from, Until: to}, &reply); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		report = reply.Report
+	}
+
+	if err := writeReport(os.Stdout, report, *format); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
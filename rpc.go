@@ -1,76 +1,331 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"time"
+)
+
+// How long Watch waits for events by default, and at most.
+const (
+	defaultWatchWait = 30 * time.Second
+	maxWatchWait     = 5 * time.Minute
 )
 
 // Request types for RPC (make sure to keep them in sync with the client)
 type ExecStateRequest struct {
 	Process int
+	Rule    string // schedule rule, eg. "mon-fri 22:00-06:00 system"
+	RuleID  int
+	Cursor  uint64        // Watch returns the events after this sequence number
+	Wait    time.Duration // how long Watch waits for an event
+	Client  string        // name of the caller, recorded in the audit log and history
+	Mode    string        // mode for Acquire and Hold: system, display or critical
+	Handle  uint64        // handle for Release and Heartbeat
+	// Acquire a lease renewed by Heartbeat calls at this interval, and
+	// dropped after MaxMissed missed beats (default 3)
+	Heartbeat time.Duration
+	MaxMissed int
+	Protocol  int    // protocol version of the client, for Hello
+	Method    string // method for Forward, eg. "System"
+	Upstream  string // upstreams for Forward, comma separated (default all)
+
+	// QueryAudit and Report period
+	Since    time.Time
+	Until    time.Time
+	Identity string
+
+	// set by the server from the connection, not transmitted
+	remote   string
+	identity string
+	conn     *connCodec
 }
 
 type ExecStateReply struct {
-	Flags     uint32
-	Processes []int
+	Flags         uint32
+	Applied       uint32 // flags applied currently, returned by Read
+	Processes     []int
+	Registrations []Registration // registered processes with their client and time, returned by Read
+	Schedules     []ScheduleRule
+	Conditions    []ConditionStatus
+	Matches       []ProcessMatch // processes matching a watch rule
+	Sessions      []Session      // remote sessions connected to the watched ports
+	Files         []FileHold     // files in the hold directory
+	Power         *PowerStatus   // nil unless a power policy is configured
+	Events        []Event
+	Cursor        uint64 // sequence number of the last event, to pass to the next Watch
+	Audit         []AuditRecord
+	Report        *UsageReport
+	Health        *Health
+	Handle        uint64         // handle returned by Acquire
+	Handles       []Handle       // handles acquired and not released yet
+	Refcounts     map[string]int // number of handles per mode
+	Capabilities  *Capabilities
+	Upstreams     []UpstreamResult // results of Forward, by upstream
+
+	// Code and Error describe the error of a failed call, in the replies of the
+	// HTTP endpoints. net/rpc drops the reply of a failed call: RPC clients get
+	// the code from the error with ErrorCodeOf.
+	Code  ErrorCode
+	Error string
 }
 
 // IMPORTANT: All methods return error to comply with net/rpc requirements
 
 // Clears all sleep flags and returns the previous flags in the reply.
 func (m *ExecStateManager) Clear(req ExecStateRequest, reply *ExecStateReply) error {
-	log.Println("ExecStateManager.Clear — Clearing sleep flags")
-	return m.setAtomicState(0, reply)
+	note := m.forceNote()
+	log.Println("ExecStateManager.Clear — Clearing sleep flags", note)
+	err := m.setAtomicState(0, req.remote != "", reply)
+	m.auditCall("Clear", req, note, err)
+	return err
 }
 
 // Sets the execution state to keep the system and display on, and returns the previous flags.
 func (m *ExecStateManager) Display(req ExecStateRequest, reply *ExecStateReply) error {
-	log.Println("ExecStateManager.Display — Forcing display ON")
-	return m.setAtomicState(ES_SYSTEM_REQUIRED|ES_DISPLAY_REQUIRED, reply)
+	note := m.forceNote()
+	log.Println("ExecStateManager.Display — Forcing display ON", note)
+	err := m.setAtomicState(ES_SYSTEM_REQUIRED|ES_DISPLAY_REQUIRED, req.remote != "", reply)
+	m.auditCall("Display", req, note, err)
+	return err
 }
 
 // Sets the execution state to keep the system on, and returns the previous flags.
 func (m *ExecStateManager) System(req ExecStateRequest, reply *ExecStateReply) error {
-	log.Println("ExecStateManager.System — Forcing system ON")
-	return m.setAtomicState(ES_SYSTEM_REQUIRED, reply)
+	note := m.forceNote()
+	log.Println("ExecStateManager.System — Forcing system ON", note)
+	err := m.setAtomicState(ES_SYSTEM_REQUIRED, req.remote != "", reply)
+	m.auditCall("System", req, note, err)
+	return err
 }
 
 // Sets the execution state to keep the system on and enable away mode, and returns the previous flags.
 func (m *ExecStateManager) Critical(req ExecStateRequest, reply *ExecStateReply) error {
-	log.Println("ExecStateManager.Critical — Forcing system critical ON")
-	return m.setAtomicState(ES_SYSTEM_REQUIRED|ES_AWAYMODE_REQUIRED, reply)
+	note := m.forceNote()
+	log.Println("ExecStateManager.Critical — Forcing system critical ON", note)
+	err := m.setAtomicState(ES_SYSTEM_REQUIRED|ES_AWAYMODE_REQUIRED, req.remote != "", reply)
+	m.auditCall("Critical", req, note, err)
+	return err
+}
+
+// Acquires a counted handle on req.Mode and returns it in the reply. The mode
+// stays in effect until the handle is released, on top of the mode set by the
+// force methods (Clear, Display, System and Critical).
+func (m *ExecStateManager) Acquire(req ExecStateRequest, reply *ExecStateReply) error {
+	h, err := m.acquire(req.Mode, req.Client, req.Heartbeat, req.MaxMissed)
+	if err != nil {
+		log.Printf("ExecStateManager.Acquire — Failed to acquire %s: %v", req.Mode, err)
+		m.auditCall("Acquire", req, req.Mode, err)
+		return err
+	}
+	if h.Heartbeat > 0 {
+		log.Printf("ExecStateManager.Acquire — Lease %d acquired for %s mode, heartbeat every %v", h.ID, h.Mode, h.Heartbeat)
+	} else {
+		log.Printf("ExecStateManager.Acquire — Handle %d acquired for %s mode", h.ID, h.Mode)
+	}
+	m.auditCall("Acquire", req, fmt.Sprintf("handle %d: %s", h.ID, h.Mode), nil)
+	reply.Handle = h.ID
+	reply.Handles = []Handle{h}
+	reply.Flags = m.getAtomicState()
+	reply.Refcounts = m.handles.refcounts()
+	return nil
+}
+
+// Holds req.Mode as long as the connection of the caller stays open, and
+// returns the handle in the reply. The handle is released when the connection
+// closes for any reason, or by Release.
+func (m *ExecStateManager) Hold(req ExecStateRequest, reply *ExecStateReply) error {
+	if req.conn == nil {
+		return newError(ErrInvalid, "hold requires a client connection")
+	}
+	h, err := m.holdConn(req.conn, req.Mode, req.Client)
+	if err != nil {
+		log.Printf("ExecStateManager.Hold — Failed to hold %s: %v", req.Mode, err)
+		m.auditCall("Hold", req, req.Mode, err)
+		return err
+	}
+	log.Printf("ExecStateManager.Hold — Handle %d acquired for %s mode, bound to %s", h.ID, h.Mode, h.Conn)
+	m.auditCall("Hold", req, fmt.Sprintf("handle %d: %s", h.ID, h.Mode), nil)
+	reply.Handle = h.ID
+	reply.Handles = []Handle{h}
+	reply.Flags = m.getAtomicState()
+	reply.Refcounts = m.handles.refcounts()
+	return nil
+}
+
+// Renews the lease req.Handle, and returns it with its new expiry in the reply.
+// An error means the lease expired: the client should acquire a new one.
+func (m *ExecStateManager) Heartbeat(req ExecStateRequest, reply *ExecStateReply) error {
+	h, err := m.handles.heartbeat(req.Handle, m.now())
+	if err != nil {
+		log.Printf("ExecStateManager.Heartbeat — %v", err)
+		return err
+	}
+	reply.Handle = h.ID
+	reply.Handles = []Handle{h}
+	return nil
+}
+
+// Releases the handle req.Handle. The state is only downgraded when the last
+// handle on a mode is released.
+func (m *ExecStateManager) Release(req ExecStateRequest, reply *ExecStateReply) error {
+	h, err := m.releaseHandle(req.Handle)
+	if err == nil {
+		log.Printf("ExecStateManager.Release — Handle %d released (%s mode)", h.ID, h.Mode)
+	} else {
+		log.Printf("ExecStateManager.Release — Failed to release handle %d: %v", req.Handle, err)
+	}
+	m.auditCall("Release", req, fmt.Sprintf("handle %d", req.Handle), err)
+	reply.Flags = m.getAtomicState()
+	reply.Refcounts = m.handles.refcounts()
+	return err
 }
 
 // Returns the previous execution state flags in the reply.
 func (m *ExecStateManager) Read(req ExecStateRequest, reply *ExecStateReply) error {
 	log.Println("ExecStateManager.Read — Returning previous flags")
 	reply.Flags = m.getAtomicState()
+	reply.Applied = m.appliedFlags()
 	reply.Processes = m.getRegisteredProcesses()
+	reply.Registrations = m.getRegistrations()
+	reply.Schedules = m.schedule.list()
+	reply.Conditions = m.getConditionStatus()
+	reply.Matches = m.getProcessMatches()
+	reply.Sessions = m.getSessions()
+	reply.Files = m.getFileHolds()
+	reply.Power = m.power.getStatus()
+	reply.Health = m.health()
+	reply.Handles = m.handles.list()
+	reply.Refcounts = m.handles.refcounts()
+	reply.Cursor = m.events.cursor()
+	return nil
+}
+
+// Waits for state changes after req.Cursor and returns them, or an empty list
+// if none happened within req.Wait. Pass the returned cursor to the next call.
+func (m *ExecStateManager) Watch(req ExecStateRequest, reply *ExecStateReply) error {
+	wait := req.Wait
+	if wait <= 0 {
+		wait = defaultWatchWait
+	}
+	reply.Events = m.waitEvents(req.Cursor, min(wait, maxWatchWait))
+	reply.Cursor = req.Cursor
+	if n := len(reply.Events); n > 0 {
+		reply.Cursor = reply.Events[n-1].Seq
+	}
 	return nil
 }
 
 // Registers a process.
 func (m *ExecStateManager) Register(req ExecStateRequest, reply *ExecStateReply) error {
 	log.Println("ExecStateManager.Register — Register process:", req.Process)
-	m.registerProcess(req.Process)
+	m.registerProcess(req.Process, req.Client)
+	m.publish(Event{Type: EventRegister, Process: req.Process, Detail: req.Client})
+	m.auditCall("Register", req, "", nil)
 	return nil
 }
 
-// Unregisters a process.
+// Unregisters a process, returns a not-found error if it was not registered.
 func (m *ExecStateManager) Unregister(req ExecStateRequest, reply *ExecStateReply) error {
 	log.Println("ExecStateManager.Unregister — Unregister process:", req.Process)
-	m.unregisterProcess(req.Process)
-	if !m.hasRegisteredProcesses() {
+	if !m.unregisterProcess(req.Process) {
+		err := newError(ErrNotFound, "process %d is not registered", req.Process)
+		m.auditCall("Unregister", req, "", err)
+		return err
+	}
+	m.publish(Event{Type: EventUnregister, Process: req.Process})
+	m.auditCall("Unregister", req, "", nil)
+	if !m.hasRegisteredProcesses() && !m.keepOnEmpty {
 		log.Println("ExecStateManager.Unregister — All processes unregistered")
 		return m.Shutdown(req, reply)
 	}
 	return nil
 }
 
+// Lists the schedule rules.
+func (m *ExecStateManager) ListSchedules(req ExecStateRequest, reply *ExecStateReply) error {
+	log.Println("ExecStateManager.ListSchedules — Returning schedule rules")
+	reply.Schedules = m.schedule.list()
+	return nil
+}
+
+// Adds a schedule rule and applies it right away if its window is open.
+func (m *ExecStateManager) AddSchedule(req ExecStateRequest, reply *ExecStateReply) error {
+	log.Println("ExecStateManager.AddSchedule — Add schedule rule:", req.Rule)
+	rule, err := parseScheduleRule(req.Rule)
+	if err != nil {
+		err = withCode(ErrInvalid, err)
+		m.auditCall("AddSchedule", req, req.Rule, err)
+		return err
+	}
+	rule = m.schedule.add(rule)
+	reply.Schedules = m.schedule.list()
+	err = m.evaluateSchedule()
+	m.auditCall("AddSchedule", req, fmt.Sprintf("rule %d: %s", rule.ID, rule), err)
+	return err
+}
+
+// Removes a schedule rule by ID.
+func (m *ExecStateManager) RemoveSchedule(req ExecStateRequest, reply *ExecStateReply) error {
+	log.Println("ExecStateManager.RemoveSchedule — Remove schedule rule:", req.RuleID)
+	if !m.schedule.remove(req.RuleID) {
+		err := newError(ErrNotFound, "no schedule rule with ID %d", req.RuleID)
+		m.auditCall("RemoveSchedule", req, "", err)
+		return err
+	}
+	reply.Schedules = m.schedule.list()
+	err := m.evaluateSchedule()
+	m.auditCall("RemoveSchedule", req, fmt.Sprintf("rule %d", req.RuleID), err)
+	return err
+}
+
+// Returns the audit log records between req.Since and req.Until, made by req.Identity.
+func (m *ExecStateManager) QueryAudit(req ExecStateRequest, reply *ExecStateReply) error {
+	log.Println("ExecStateManager.QueryAudit — Returning audit records")
+	if m.audit == nil {
+		return newError(ErrUnsupported, "audit log is not enabled")
+	}
+	records, err := queryAuditLog(m.audit.path, req.Since, req.Until, req.Identity)
+	reply.Audit = records
+	return withCode(ErrInternal, err)
+}
+
+// Returns the time spent in each mode and held awake by each registrant between
+// req.Since and req.Until.
+func (m *ExecStateManager) Report(req ExecStateRequest, reply *ExecStateReply) error {
+	log.Println("ExecStateManager.Report — Returning usage report")
+	reply.Report = buildReport(m.history.list(m.now()), req.Since, req.Until)
+	return nil
+}
+
+// Returns the health of the server: backend status, last backend error,
+// listener state and uptime.
+func (m *ExecStateManager) Ping(req ExecStateRequest, reply *ExecStateReply) error {
+	reply.Health = m.health()
+	reply.Flags = m.getAtomicState()
+	return nil
+}
+
+// Returns the version and capabilities of the server in the reply. It does
+// not fail for an incompatible client, which is rather logged: the client
+// checks the reply with Capabilities.Check.
+func (m *ExecStateManager) Hello(req ExecStateRequest, reply *ExecStateReply) error {
+	reply.Capabilities = m.capabilities()
+	if req.Protocol != 0 {
+		if err := reply.Capabilities.Check(req.Protocol); err != nil {
+			log.Printf("ExecStateManager.Hello — Client %q (%s): %v", req.Client, req.remote, err)
+		}
+	}
+	return nil
+}
+
 // Shuts down the RPC server.
 func (m *ExecStateManager) Shutdown(req ExecStateRequest, reply *ExecStateReply) error {
 	log.Println("ExecStateManager.Shutdown - Shutting down RPC server")
 
 	// Close the listener to stop accepting new connections, assuming
 	// ExcecStateManager.Stop will be called via defer in main()
-	return m.listener.Close()
+	err := m.listener.Close()
+	m.auditCall("Shutdown", req, "", err)
+	return err
 }
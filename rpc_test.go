@@ -38,13 +38,15 @@ func setupTestServer(t *testing.T) (*ExecStateManager, net.Listener, *rpc.Client
 		t.Fatalf("rpc.Register failed: %v", err)
 	}
 
+	manager.listening.Store(true)
 	go func() {
 		for {
 			conn, err := listener.Accept()
 			if err != nil {
+				manager.listening.Store(false)
 				return // Listener closed
 			}
-			go rpc.ServeConn(conn)
+			manager.serveConn(conn)
 		}
 	}()
 
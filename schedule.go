@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often the schedule rules are evaluated.
+const scheduleInterval = 30 * time.Second
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ScheduleRule keeps the computer awake in Mode during a weekly time window.
+//
+// Start and End are minutes since midnight. A window where End <= Start wraps
+// past midnight and belongs to the day it starts on, so "mon 22:00-06:00"
+// covers Monday 22:00 until Tuesday 06:00.
+type ScheduleRule struct {
+	ID    int
+	Days  []time.Weekday
+	Start int
+	End   int
+	Mode  string
+}
+
+// parseScheduleRule parses a rule like "mon-fri 22:00-06:00 system".
+//
+// Days are a comma separated list of days or day ranges, or "*" for every day.
+// The mode is optional and defaults to system.
+func parseScheduleRule(s string) (ScheduleRule, error) {
+	var rule ScheduleRule
+
+	fields := strings.Fields(s)
+	if len(fields) < 2 || len(fields) > 3 {
+		return rule, fmt.Errorf("invalid schedule rule %q (expected DAYS HH:MM-HH:MM [MODE])", s)
+	}
+
+	days, err := parseDays(fields[0])
+	if err != nil {
+		return rule, err
+	}
+	rule.Days = days
+
+	from, to, ok := strings.Cut(fields[1], "-")
+	if !ok {
+		return rule, fmt.Errorf("invalid time range %q (expected HH:MM-HH:MM)", fields[1])
+	}
+	if rule.Start, err = parseClock(from); err != nil {
+		return rule, err
+	}
+	if rule.End, err = parseClock(to); err != nil {
+		return rule, err
+	}
+
+	rule.Mode = "system"
+	if len(fields) == 3 {
+		rule.Mode = strings.ToLower(fields[2])
+	}
+	if flags, err := parseMode(rule.Mode); err != nil {
+		return rule, err
+	} else if flags == 0 {
+		return rule, fmt.Errorf("schedule rule %q must keep the computer awake", s)
+	}
+	return rule, nil
+}
+
+// parseDays parses "mon-fri", "sat,sun", "mon,wed-fri" or "*".
+func parseDays(s string) ([]time.Weekday, error) {
+	if s == "*" {
+		return []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday,
+			time.Thursday, time.Friday, time.Saturday}, nil
+	}
+
+	var days []time.Weekday
+	for _, part := range strings.Split(strings.ToLower(s), ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		first, ok := weekdays[from]
+		if !ok {
+			return nil, fmt.Errorf("invalid day %q", from)
+		}
+		last := first
+		if isRange {
+			if last, ok = weekdays[to]; !ok {
+				return nil, fmt.Errorf("invalid day %q", to)
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			if !slices.Contains(days, d) {
+				days = append(days, d)
+			}
+			if d == last {
+				break
+			}
+		}
+	}
+	slices.Sort(days)
+	return days, nil
+}
+
+// parseClock converts "HH:MM" into minutes since midnight.
+func parseClock(s string) (int, error) {
+	h, m, ok := strings.Cut(s, ":")
+	hours, err1 := strconv.Atoi(h)
+	minutes, err2 := strconv.Atoi(m)
+	if !ok || err1 != nil || err2 != nil || hours < 0 || hours > 24 || minutes < 0 || minutes > 59 || (hours == 24 && minutes != 0) {
+		return 0, fmt.Errorf("invalid time %q (expected HH:MM)", s)
+	}
+	return hours*60 + minutes, nil
+}
+
+// String formats the rule the way parseScheduleRule expects it.
+func (r ScheduleRule) String() string {
+	names := make([]string, len(r.Days))
+	for i, d := range r.Days {
+		names[i] = strings.ToLower(d.String()[:3])
+	}
+	return fmt.Sprintf("%s %02d:%02d-%02d:%02d %s", strings.Join(names, ","),
+		r.Start/60, r.Start%60, r.End/60, r.End%60, r.Mode)
+}
+
+// activeAt reports whether t falls inside the rule's window.
+func (r ScheduleRule) activeAt(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	today := slices.Contains(r.Days, t.Weekday())
+	if r.Start < r.End {
+		return today && minute >= r.Start && minute < r.End
+	}
+	// window wraps past midnight (or spans the whole day if Start == End)
+	yesterday := slices.Contains(r.Days, (t.Weekday()+6)%7)
+	return (today && minute >= r.Start) || (yesterday && minute < r.End)
+}
+
+// schedule holds the rules and the state they last applied.
+type schedule struct {
+	mu      sync.Mutex
+	rules   []ScheduleRule
+	nextID  int
+	applied bool
+	flags   uint32
+}
+
+// active reports whether a window with a mode other than clear is open at t.
+func (s *schedule) active(t time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.ContainsFunc(s.rules, func(r ScheduleRule) bool {
+		return modes[r.Mode] != 0 && r.activeAt(t)
+	})
+}
+
+// add assigns an ID to the rule and appends it to the schedule.
+func (s *schedule) add(rule ScheduleRule) ScheduleRule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	rule.ID = s.nextID
+	s.rules = append(s.rules, rule)
+	return rule
+}
+
+// remove deletes the rule with the given ID and reports whether it existed.
+func (s *schedule) remove(id int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.rules)
+	s.rules = slices.DeleteFunc(s.rules, func(r ScheduleRule) bool { return r.ID == id })
+	if len(s.rules) == 0 {
+		// nothing drives the state anymore, the next rule starts from scratch
+		s.applied = false
+	}
+	return len(s.rules) != n
+}
+
+func (s *schedule) list() []ScheduleRule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.rules)
+}
+
+// transition returns the flags wanted by the rules active at t, and whether
+// they differ from the flags applied by the previous evaluation. Overlapping
+// windows combine their modes.
+func (s *schedule) transition(t time.Time) (uint32, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.rules) == 0 {
+		return 0, false
+	}
+	var flags uint32
+	for _, r := range s.rules {
+		if r.activeAt(t) {
+			flags |= modes[r.Mode]
+		}
+	}
+	if s.applied && flags == s.flags {
+		return flags, false
+	}
+	s.applied = true
+	s.flags = flags
+	return flags, true
+}
+
+// evaluateSchedule applies the scheduled mode when a window opens or closes.
+// Outside of all windows the state is cleared so the computer may sleep.
+// Modes set via RPC in between are left alone until the next transition.
+func (m *ExecStateManager) evaluateSchedule() error {
+	flags, changed := m.schedule.transition(m.now())
+	if !changed {
+		return nil
+	}
+	log.Printf("Schedule — switching to %s mode", modeName(flags))
+	return m.setAtomicState(flags, false, &ExecStateReply{})
+}
+
+// runSchedule evaluates the schedule periodically until the manager stops.
+func (m *ExecStateManager) runSchedule(interval time.Duration) {
+	m.poll(interval, func() {
+		if err := m.evaluateSchedule(); err != nil {
+			log.Printf("Schedule evaluation error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseScheduleRule(t *testing.T) {
+	testCases := []struct {
+		rule    string
+		want    string
+		wantErr bool
+	}{
+		{"mon-fri 22:00-06:00 system", "mon,tue,wed,thu,fri 22:00-06:00 system", false},
+		{"sat,sun 08:00-20:00 display", "sun,sat 08:00-20:00 display", false},
+		{"fri-mon 00:00-24:00", "sun,mon,fri,sat 00:00-24:00 system", false},
+		{"* 09:30-17:15 Critical", "sun,mon,tue,wed,thu,fri,sat 09:30-17:15 critical", false},
+		{"mon 22:00", "", true},
+		{"mon 22:00-06:00 clear", "", true},
+		{"xyz 22:00-06:00", "", true},
+		{"mon 25:00-06:00", "", true},
+		{"mon 22:00-06:00 turbo", "", true},
+	}
+
+	for _, tc := range testCases {
+		rule, err := parseScheduleRule(tc.rule)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("parseScheduleRule(%q): expected an error", tc.rule)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseScheduleRule(%q): unexpected error: %v", tc.rule, err)
+			continue
+		}
+		if got := rule.String(); got != tc.want {
+			t.Errorf("parseScheduleRule(%q) = %q, want %q", tc.rule, got, tc.want)
+		}
+	}
+}
+
+func TestScheduleRuleActiveAt(t *testing.T) {
+	rule, err := parseScheduleRule("mon-fri 22:00-06:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2026-03-02 is a Monday
+	testCases := []struct {
+		at   string
+		want bool
+	}{
+		{"2026-03-02 21:59", false}, // monday evening
+		{"2026-03-02 22:00", true},  // monday night
+		{"2026-03-03 05:59", true},  // tuesday morning, monday's window
+		{"2026-03-03 06:00", false}, // tuesday morning, window closed
+		{"2026-03-02 03:00", false}, // monday morning, sunday has no window
+		{"2026-03-07 03:00", true},  // saturday morning, friday's window
+		{"2026-03-07 23:00", false}, // saturday night
+	}
+
+	for _, tc := range testCases {
+		at, _ := time.ParseInLocation("2006-01-02 15:04", tc.at, time.Local)
+		if got := rule.activeAt(at); got != tc.want {
+			t.Errorf("activeAt(%s) = %v, want %v", tc.at, got, tc.want)
+		}
+	}
+}
+
+func TestScheduleTransition(t *testing.T) {
+	var s schedule
+	if _, changed := s.transition(time.Now()); changed {
+		t.Error("an empty schedule should never change the state")
+	}
+
+	night, _ := parseScheduleRule("mon-fri 22:00-06:00 system")
+	evening, _ := parseScheduleRule("mon 20:00-23:00 display")
+	s.add(night)
+	s.add(evening)
+
+	steps := []struct {
+		at      string
+		want    uint32
+		changed bool
+	}{
+		{"2026-03-02 12:00", 0, true}, // first evaluation always applies
+		{"2026-03-02 13:00", 0, false},
+		{"2026-03-02 20:00", ES_SYSTEM_REQUIRED | ES_DISPLAY_REQUIRED, true},
+		{"2026-03-02 22:30", ES_SYSTEM_REQUIRED | ES_DISPLAY_REQUIRED, false},
+		{"2026-03-02 23:00", ES_SYSTEM_REQUIRED, true},
+		{"2026-03-03 06:00", 0, true},
+	}
+
+	for _, step := range steps {
+		at, _ := time.ParseInLocation("2006-01-02 15:04", step.at, time.Local)
+		flags, changed := s.transition(at)
+		if flags != step.want || changed != step.changed {
+			t.Errorf("transition(%s) = (0x%X, %v), want (0x%X, %v)", step.at, flags, changed, step.want, step.changed)
+		}
+	}
+}
+
+func TestScheduleRPC(t *testing.T) {
+	now, _ := time.ParseInLocation("2006-01-02 15:04", "2026-03-02 23:00", time.Local)
+	manager := &ExecStateManager{clock: func() time.Time { return now }}
+	manager.Start()
+	defer manager.Stop()
+
+	var reply ExecStateReply
+	if err := manager.AddSchedule(ExecStateRequest{Rule: "mon-fri 22:00-06:00"}, &reply); err != nil {
+		t.Fatalf("AddSchedule: %v", err)
+	}
+	if err := manager.AddSchedule(ExecStateRequest{Rule: "sat 10:00-12:00 display"}, &reply); err != nil {
+		t.Fatalf("AddSchedule: %v", err)
+	}
+	if err := manager.AddSchedule(ExecStateRequest{Rule: "sat 10:00"}, &reply); err == nil {
+		t.Error("AddSchedule with an invalid rule should fail")
+	}
+	if manager.schedule.flags != ES_SYSTEM_REQUIRED {
+		t.Errorf("expected the night window to be applied, got 0x%X", manager.schedule.flags)
+	}
+
+	if err := manager.RemoveSchedule(ExecStateRequest{RuleID: 1}, &reply); err != nil {
+		t.Fatalf("RemoveSchedule: %v", err)
+	}
+	if err := manager.RemoveSchedule(ExecStateRequest{RuleID: 42}, &reply); err == nil {
+		t.Error("RemoveSchedule of an unknown rule should fail")
+	}
+	if manager.schedule.flags != 0 {
+		t.Errorf("expected the state to be cleared outside of the remaining window, got 0x%X", manager.schedule.flags)
+	}
+
+	if err := manager.ListSchedules(ExecStateRequest{}, &reply); err != nil {
+		t.Fatalf("ListSchedules: %v", err)
+	}
+	if len(reply.Schedules) != 1 || reply.Schedules[0].ID != 2 || !slices.Contains(reply.Schedules[0].Days, time.Saturday) {
+		t.Errorf("expected only the saturday rule to remain, got %v", reply.Schedules)
+	}
+}
@@ -5,21 +5,51 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"net/rpc"
 	"os"
 	"os/signal"
+	"slices"
+	"syscall"
+	"time"
 )
 
-func serve(cfg *Config) {
-	// Configure listener
-	address := fmt.Sprintf("%s:%d", cfg.address, cfg.port)
-	listener, err := net.Listen(cfg.network, address)
+// serve runs the server until it is shut down. Errors are returned rather than
+// fatal, so that the deferred shutdown clears the state and closes the
+// listeners, which removes unix socket files.
+func serve(cfg *Config) error {
+	// Configure listeners, passed by systemd with socket activation, given by
+	// --listen, or else from the network, address and port options
+	listeners, err := listenFDs()
 	if err != nil {
-		log.Fatalf("Failed to listen on %s: %v", address, err)
+		return fmt.Errorf("socket activation failed: %w", err)
 	}
+	switch {
+	case len(listeners) > 0:
+		log.Printf("Using %d socket(s) passed by systemd", len(listeners))
+	case len(cfg.listens) > 0:
+		if listeners, err = listenAll(cfg.listens); err != nil {
+			return fmt.Errorf("failed to listen: %w", err)
+		}
+	default:
+		address := serverAddress(cfg)
+		listener, err := net.Listen(cfg.network, address)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", address, err)
+		}
+		listeners = []net.Listener{listener}
+	}
+	// closing listener closes all of them
+	listener := listeners[0]
+	if len(listeners) > 1 {
+		listener = newMultiListener(listeners)
+	}
+	defer listener.Close() //nolint:errcheck
 
+	// CTRL+C from the console, SIGTERM from service managers and containers,
+	// SIGHUP when the terminal is closed
 	interruptCh := make(chan os.Signal, 1)
-	signal.Notify(interruptCh, os.Interrupt)
+	signal.Notify(interruptCh, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 	defer signal.Stop(interruptCh)
 
 	doneCh := make(chan struct{})
@@ -27,8 +57,8 @@ func serve(cfg *Config) {
 
 	go func() {
 		select {
-		case <-interruptCh:
-			log.Println("Received CTRL+C, shutting down server")
+		case sig := <-interruptCh:
+			log.Printf("Received %v, shutting down server", sig)
 			if closeErr := listener.Close(); closeErr != nil && !errors.Is(closeErr, net.ErrClosed) {
 				log.Printf("listener close error during shutdown: %v", closeErr)
 			}
@@ -37,27 +67,176 @@ func serve(cfg *Config) {
 	}()
 
 	// Configure and start ExecStateManager
-	manager := &ExecStateManager{listener: listener}
+	manager := &ExecStateManager{listener: listener, reassertInterval: cfg.reassert, keepOnEmpty: !cfg.exitOnEmpty, web: cfg.web}
+	if cfg.web && cfg.httpAddr == "" {
+		return errors.New("--web requires --http")
+	}
+	manager.webTokens = listenerTokens(listeners)
+	if cfg.httpAddr != "" && !isLoopbackAddr(cfg.httpAddr) {
+		if cfg.web && len(manager.webTokens) == 0 {
+			return fmt.Errorf("--web on %s requires a --listen socket with a token, the admin page would be open to the network", cfg.httpAddr)
+		}
+		log.Printf("Warning: /events, /healthz, /readyz and /metrics on %s are open to the network", cfg.httpAddr)
+	}
+	for _, s := range cfg.upstreams {
+		upstream, err := parseUpstream(s)
+		if err != nil {
+			return fmt.Errorf("invalid upstream: %w", err)
+		}
+		if slices.ContainsFunc(manager.upstreams, func(u Upstream) bool { return u.Name == upstream.Name }) {
+			return fmt.Errorf("invalid upstream: duplicate name %q", upstream.Name)
+		}
+		manager.upstreams = append(manager.upstreams, upstream)
+		log.Printf("Relaying Forward calls to %s (%s)", upstream.Name, upstream.Address)
+	}
+	if cfg.auditPath != "" {
+		audit, err := openAuditLog(cfg.auditPath)
+		if err != nil {
+			return fmt.Errorf("failed to open audit log %s: %w", cfg.auditPath, err)
+		}
+		defer audit.Close() //nolint:errcheck
+		manager.audit = audit
+	}
+	if cfg.history != "" {
+		if err := manager.history.open(cfg.history); err != nil {
+			return fmt.Errorf("failed to open history %s: %w", cfg.history, err)
+		}
+		defer manager.history.close() //nolint:errcheck
+	}
+	if cfg.downgrade || cfg.releaseAt > 0 {
+		source := defaultPowerSource()
+		if cfg.powerRoot != "" {
+			source = sysfsPowerSource{root: cfg.powerRoot}
+		}
+		manager.power = &powerPolicy{source: source, downgrade: cfg.downgrade, releaseBelow: cfg.releaseAt}
+		// read the power status before the initial state is applied
+		if _, err := manager.power.update(); err != nil {
+			log.Printf("Power status error: %v", err)
+		}
+	}
+	var httpListener net.Listener
+	if cfg.httpAddr != "" {
+		if httpListener, err = net.Listen("tcp", cfg.httpAddr); err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", cfg.httpAddr, err)
+		}
+		defer httpListener.Close() //nolint:errcheck
+	}
 	manager.Start()
+
+	// Serve HTTP endpoints, closed after the manager stopped so that
+	// subscribers receive the shutdown event
+	if httpListener != nil {
+		httpServer := &http.Server{Handler: manager.httpHandler(), ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := httpServer.Serve(httpListener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("HTTP server error: %v", err)
+			}
+		}()
+		defer httpServer.Close() //nolint:errcheck
+		log.Printf("HTTP server listening on %s", cfg.httpAddr)
+	}
 	defer manager.Stop()
 
-	// set the initial sleep mode
-	if cfg.display {
+	for _, s := range cfg.schedules {
+		rule, err := parseScheduleRule(s)
+		if err != nil {
+			return fmt.Errorf("invalid schedule: %w", err)
+		}
+		manager.schedule.add(rule)
+	}
+
+	// set the initial sleep mode (the schedule decides if there is one)
+	if len(cfg.schedules) > 0 {
+		if err := manager.evaluateSchedule(); err != nil {
+			return fmt.Errorf("failed to set initial scheduled state: %w", err)
+		}
+	} else if cfg.display {
 		if err := manager.Display(ExecStateRequest{}, &ExecStateReply{}); err != nil {
-			log.Fatalf("Failed to set initial display state: %v", err)
+			return fmt.Errorf("failed to set initial display state: %w", err)
 		}
 	} else {
 		if err := manager.System(ExecStateRequest{}, &ExecStateReply{}); err != nil {
-			log.Fatalf("Failed to set initial system state: %v", err)
+			return fmt.Errorf("failed to set initial system state: %w", err)
+		}
+	}
+
+	if cfg.cpuLimit > 0 || cfg.diskLimit > 0 {
+		source := defaultActivitySource()
+		if cfg.procRoot != "" {
+			source = procActivitySource{root: cfg.procRoot}
+		}
+		activity := &activityCondition{
+			source:        source,
+			cpuThreshold:  cfg.cpuLimit,
+			diskThreshold: cfg.diskLimit * 1e6,
+		}
+		manager.addCondition(newConditionMonitor("activity", activity, ES_SYSTEM_REQUIRED, cfg.cooldown))
+	}
+
+	if cfg.netLimit > 0 {
+		source := defaultNetworkSource()
+		if cfg.netRoot != "" {
+			source = sysfsNetworkSource{root: cfg.netRoot}
+		}
+		network := &networkCondition{
+			source:     source,
+			threshold:  cfg.netLimit * 1e6,
+			interfaces: cfg.netIfaces,
+		}
+		manager.addCondition(newConditionMonitor("network", network, ES_SYSTEM_REQUIRED, cfg.cooldown))
+	}
+
+	scanner := &processScanner{source: defaultProcessSource()}
+	for _, w := range cfg.watches {
+		pattern, flags, err := parseWatchRule(w)
+		if err != nil {
+			return fmt.Errorf("invalid watch rule: %w", err)
 		}
+		watch := &processCondition{scanner: scanner, rule: pattern}
+		manager.addCondition(newConditionMonitor("process:"+pattern, watch, flags, 0))
 	}
 
+	if cfg.sessPorts != "" {
+		ports, err := parsePorts(cfg.sessPorts)
+		if err != nil {
+			return fmt.Errorf("invalid session ports: %w", err)
+		}
+		sessions := &sessionCondition{source: defaultTCPSource(), ports: ports}
+		manager.addCondition(newConditionMonitor("sessions", sessions, ES_SYSTEM_REQUIRED, 0))
+	}
+
+	if cfg.holdDir != "" {
+		if err := os.MkdirAll(cfg.holdDir, 0o755); err != nil {
+			return fmt.Errorf("failed to create hold directory: %w", err)
+		}
+		files := &fileHoldCondition{dir: cfg.holdDir, maxAge: cfg.holdAge}
+		files.onExpire = func(name string) {
+			manager.publish(Event{Type: EventExpire, Detail: "file:" + name})
+		}
+		monitor := newConditionMonitor("files", files, ES_SYSTEM_REQUIRED, 0)
+		manager.addCondition(monitor)
+		go manager.watchHoldDir(cfg.holdDir, monitor)
+	}
+
+	go manager.runSchedule(scheduleInterval)
+	go manager.runPowerPolicy(conditionInterval)
+	go manager.runConditions(conditionInterval)
+	go manager.runIdleTimeout(cfg.idleTimeout)
+	go manager.runLeases(leaseCheckInterval)
+
 	// Register RPC server with ExecStateManager methods
 	if err := rpc.Register(manager); err != nil {
-		log.Fatalf("Failed to register RPC server: %v", err)
+		return fmt.Errorf("failed to register RPC server: %w", err)
 	}
 
-	log.Printf("RPC server listening on %s (%s)", address, cfg.network)
+	logListeners(listeners)
+	manager.listening.Store(true)
+	if err := sdNotify("READY=1\n" + manager.notifyStatus()); err != nil {
+		log.Printf("sd_notify error: %v", err)
+	}
+	if os.Getenv("NOTIFY_SOCKET") != "" {
+		go manager.runNotify(watchdogInterval())
+	}
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
@@ -69,7 +248,15 @@ func serve(cfg *Config) {
 			log.Printf("accept error: %v", err)
 			continue
 		}
-		go rpc.ServeConn(conn)
+		manager.serveConn(conn)
+	}
+	manager.listening.Store(false)
+	if err := sdNotify("STOPPING=1"); err != nil {
+		log.Printf("sd_notify error: %v", err)
 	}
+
+	// finish the calls in progress before the state is cleared by manager.Stop
+	manager.drain(cfg.drainTimeout)
 	log.Println("RPC server shutdown complete.")
+	return nil
 }
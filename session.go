@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// State of an established connection in /proc/net/tcp.
+const procTCPEstablished = "01"
+
+// tcpConn is an established TCP connection.
+type tcpConn struct {
+	local  netip.AddrPort
+	remote netip.AddrPort
+}
+
+// A tcpSource lists the established TCP connections.
+type tcpSource interface {
+	established() ([]tcpConn, error)
+}
+
+// procTCPSource reads the connections from the tcp and tcp6 tables of root,
+// which is /proc/net on Linux.
+type procTCPSource struct {
+	root string
+}
+
+func (p procTCPSource) established() ([]tcpConn, error) {
+	var conns []tcpConn
+	for _, file := range []string{"tcp", "tcp6"} {
+		data, err := os.ReadFile(filepath.Join(p.root, file))
+		if os.IsNotExist(err) {
+			continue // IPv6 disabled
+		} else if err != nil {
+			return nil, err
+		}
+		// sl local_address rem_address st ...
+		for _, line := range strings.Split(string(data), "\n")[1:] {
+			fields := strings.Fields(line)
+			if len(fields) < 4 || fields[3] != procTCPEstablished {
+				continue
+			}
+			local, err1 := parseProcAddr(fields[1])
+			remote, err2 := parseProcAddr(fields[2])
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("%s: invalid line %q", file, line)
+			}
+			conns = append(conns, tcpConn{local: local, remote: remote})
+		}
+	}
+	return conns, nil
+}
+
+// parseProcAddr parses "0100007F:0016", where the address is made of 32 bits
+// words in host byte order (little endian) and the port is big endian.
+func parseProcAddr(s string) (netip.AddrPort, error) {
+	addrHex, portHex, ok := strings.Cut(s, ":")
+	if !ok {
+		return netip.AddrPort{}, fmt.Errorf("invalid address %q", s)
+	}
+	b, err := hex.DecodeString(addrHex)
+	if err != nil || (len(b) != 4 && len(b) != 16) {
+		return netip.AddrPort{}, fmt.Errorf("invalid address %q", s)
+	}
+	for i := 0; i < len(b); i += 4 {
+		b[i], b[i+1], b[i+2], b[i+3] = b[i+3], b[i+2], b[i+1], b[i]
+	}
+	port, err := strconv.ParseUint(portHex, 16, 16)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid port %q", s)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return netip.AddrPortFrom(addr.Unmap(), uint16(port)), nil
+}
+
+// Session is an inbound connection to one of the watched ports, like an SSH
+// or RDP session.
+type Session struct {
+	Port   int
+	Remote string
+}
+
+// sessionCondition is met while there are established connections to the
+// watched local ports.
+type sessionCondition struct {
+	source tcpSource
+	ports  []int
+
+	mu       sync.Mutex
+	sessions []Session
+}
+
+func (s *sessionCondition) check(now time.Time) (conditionResult, error) {
+	conns, err := s.source.established()
+	if err != nil {
+		return conditionResult{}, err
+	}
+
+	var sessions []Session
+	for _, c := range conns {
+		if slices.Contains(s.ports, int(c.local.Port())) {
+			sessions = append(sessions, Session{Port: int(c.local.Port()), Remote: c.remote.String()})
+		}
+	}
+
+	s.mu.Lock()
+	s.sessions = sessions
+	s.mu.Unlock()
+
+	result := conditionResult{
+		met:      len(sessions) > 0,
+		readings: map[string]float64{"sessions": float64(len(sessions))},
+	}
+	if result.met {
+		result.reason = fmt.Sprintf("%d remote session(s) connected", len(sessions))
+	} else {
+		result.reason = "no remote session"
+	}
+	return result, nil
+}
+
+func (s *sessionCondition) getSessions() []Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.sessions)
+}
+
+// getSessions returns the sessions detected by the session condition.
+func (m *ExecStateManager) getSessions() []Session {
+	var sessions []Session
+	for _, c := range m.conditions {
+		if s, ok := c.cond.(*sessionCondition); ok {
+			sessions = append(sessions, s.getSessions()...)
+		}
+	}
+	return sessions
+}
+
+// parsePorts parses a comma separated list of ports.
+func parsePorts(s string) ([]int, error) {
+	var ports []int
+	for _, field := range strings.Split(s, ",") {
+		port, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("invalid port %q", field)
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
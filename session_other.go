@@ -0,0 +1,7 @@
+//go:build !windows
+
+package main
+
+func defaultTCPSource() tcpSource {
+	return procTCPSource{root: "/proc/net"}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+const procTCPFixture = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1 1 0000000000000000 100 0 0 10 0
+   1: 0F02000A:0016 0202000A:C350 01 00000000:00000000 02:000A7D8E 00000000     0        0 2 4 0000000000000000 20 4 29 10 -1
+   2: 0F02000A:A1B2 08080808:01BB 01 00000000:00000000 00:00000000 00000000  1000        0 3 1 0000000000000000 20 4 30 10 -1
+`
+
+const procTCP6Fixture = `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000001000000:0D3D 00000000000000000000000001000000:D431 01 00000000:00000000 00:00000000 00000000     0        0 4 1 0000000000000000 20 4 30 10 -1
+`
+
+func TestParseProcAddr(t *testing.T) {
+	testCases := []struct {
+		in   string
+		want string
+	}{
+		{"0100007F:0016", "127.0.0.1:22"},
+		{"0F02000A:0D3D", "10.0.2.15:3389"},
+		{"00000000000000000000000001000000:0D3D", "[::1]:3389"},
+		{"0000000000000000FFFF00000F02000A:0016", "10.0.2.15:22"},
+	}
+	for _, tc := range testCases {
+		got, err := parseProcAddr(tc.in)
+		if err != nil || got.String() != tc.want {
+			t.Errorf("parseProcAddr(%q) = %v, %v, want %s", tc.in, got, err, tc.want)
+		}
+	}
+	if _, err := parseProcAddr("0100007F"); err == nil {
+		t.Error("expected an error for an address without port")
+	}
+}
+
+func TestSessionCondition(t *testing.T) {
+	root := t.TempDir()
+	writeFixture(t, root, map[string]string{"tcp": procTCPFixture, "tcp6": procTCP6Fixture})
+
+	sessions := &sessionCondition{source: procTCPSource{root: root}, ports: []int{22, 3389}}
+	result, err := sessions.check(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.met {
+		t.Errorf("expected sessions to be detected: %+v", result)
+	}
+
+	// the listening socket and the outbound connection are not sessions
+	got := sessions.getSessions()
+	want := []Session{{Port: 22, Remote: "10.0.2.2:50000"}, {Port: 3389, Remote: "[::1]:54321"}}
+	if len(got) != len(want) {
+		t.Fatalf("expected sessions %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected session %v, got %v", want[i], got[i])
+		}
+	}
+
+	sessions.ports = []int{5900}
+	if result, _ = sessions.check(time.Now()); result.met {
+		t.Errorf("expected no session on port 5900: %+v", result)
+	}
+}
+
+func TestParsePorts(t *testing.T) {
+	ports, err := parsePorts("22, 3389")
+	if err != nil || len(ports) != 2 || ports[0] != 22 || ports[1] != 3389 {
+		t.Errorf("parsePorts = %v, %v", ports, err)
+	}
+	for _, invalid := range []string{"", "ssh", "0", "70000"} {
+		if _, err := parsePorts(invalid); err == nil {
+			t.Errorf("parsePorts(%q): expected an error", invalid)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+//go:build windows
+
+package main
+
+import "net/netip"
+
+// windowsTCPSource lists the connections from the IPv4 and IPv6 TCP tables.
+type windowsTCPSource struct{}
+
+func (windowsTCPSource) established() ([]tcpConn, error) {
+	rows, err := GetTcpTable()
+	if err != nil {
+		return nil, err
+	}
+	var conns []tcpConn
+	for _, row := range rows {
+		if row.State == MIB_TCP_STATE_ESTAB {
+			conns = append(conns, tcpConn{
+				local:  netip.AddrPortFrom(ipv4(row.LocalAddr), ntohs(row.LocalPort)),
+				remote: netip.AddrPortFrom(ipv4(row.RemoteAddr), ntohs(row.RemotePort)),
+			})
+		}
+	}
+
+	rows6, err := GetTcp6Table()
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows6 {
+		if row.State == MIB_TCP_STATE_ESTAB {
+			conns = append(conns, tcpConn{
+				local:  netip.AddrPortFrom(netip.AddrFrom16(row.LocalAddr).Unmap(), ntohs(row.LocalPort)),
+				remote: netip.AddrPortFrom(netip.AddrFrom16(row.RemoteAddr).Unmap(), ntohs(row.RemotePort)),
+			})
+		}
+	}
+	return conns, nil
+}
+
+// ipv4 converts an address in network byte order, read as a little endian DWORD.
+func ipv4(addr uint32) netip.Addr {
+	return netip.AddrFrom4([4]byte{byte(addr), byte(addr >> 8), byte(addr >> 16), byte(addr >> 24)})
+}
+
+// ntohs converts a port in network byte order, stored in the low bits of a DWORD.
+func ntohs(port uint32) uint16 {
+	return uint16(port>>8&0xff | port&0xff<<8)
+}
+
+func defaultTCPSource() tcpSource {
+	return windowsTCPSource{}
+}
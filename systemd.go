@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// First file descriptor passed by systemd socket activation.
+const listenFDsStart = 3
+
+// listenFDs returns the listeners passed by systemd socket activation
+// (LISTEN_PID and LISTEN_FDS), or nil if the process was not socket activated.
+// The variables are unset so that child processes do not inherit them.
+func listenFDs() ([]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")     //nolint:errcheck
+	defer os.Unsetenv("LISTEN_FDS")     //nolint:errcheck
+	defer os.Unsetenv("LISTEN_FDNAMES") //nolint:errcheck
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+
+	var listeners []net.Listener
+	for fd := listenFDsStart; fd < listenFDsStart+n; fd++ {
+		file := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		listener, err := net.FileListener(file)
+		file.Close() //nolint:errcheck
+		if err != nil {
+			return nil, fmt.Errorf("socket activation fd %d: %w", fd, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// sdNotify sends a state like "READY=1" to the service manager on the datagram
+// socket in NOTIFY_SOCKET. It does nothing if the variable is not set.
+func sdNotify(state string) error {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return nil
+	}
+	// a leading @ is an abstract socket, which net handles on Linux
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close() //nolint:errcheck
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdogInterval returns the interval at which the service manager expects
+// WATCHDOG=1 notifications (half of WATCHDOG_USEC), or 0 if it does not.
+func watchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// notifyStatus returns the STATUS= line shown by systemctl status.
+func (m *ExecStateManager) notifyStatus() string {
+	h := m.health()
+	status := fmt.Sprintf("STATUS=%s mode, %d registered process(es)", modeName(m.appliedFlags()), len(m.getRegisteredProcesses()))
+	if h.Status != HealthOK {
+		status += ", " + h.Status
+	}
+	return status
+}
+
+// runNotify keeps the service manager informed until the manager stops: the
+// status on every event, and watchdog keep-alives while the server is healthy,
+// so that systemd restarts a server whose backend keeps failing.
+func (m *ExecStateManager) runNotify(watchdog time.Duration) {
+	var tick <-chan time.Time
+	if watchdog > 0 {
+		ticker := time.NewTicker(watchdog)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	cursor := m.events.cursor()
+	for {
+		events, changed := m.events.since(cursor)
+		if len(events) > 0 {
+			cursor = events[len(events)-1].Seq
+			if err := sdNotify(m.notifyStatus()); err != nil {
+				log.Printf("sd_notify error: %v", err)
+			}
+		}
+
+		select {
+		case <-changed:
+		case <-tick:
+			if m.health().Status != HealthOK {
+				log.Println("Health — server degraded, watchdog keep-alive skipped")
+				continue
+			}
+			if err := sdNotify("WATCHDOG=1"); err != nil {
+				log.Printf("sd_notify error: %v", err)
+			}
+		case <-m.mgrShutdownCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"net"
+	"net/rpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNotifySocket listens on a datagram socket set as NOTIFY_SOCKET, and
+// returns a channel receiving the notifications.
+func fakeNotifySocket(t *testing.T) <-chan string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("datagram unix sockets are not available on Windows")
+	}
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	ch := make(chan string, 100)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			ch <- string(buf[:n])
+		}
+	}()
+	return ch
+}
+
+// receive returns the next notification containing want, skipping the others.
+func receive(t *testing.T, ch <-chan string, want string) string {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-ch:
+			if strings.Contains(msg, want) {
+				return msg
+			}
+		case <-timeout:
+			t.Fatalf("no notification containing %q", want)
+		}
+	}
+}
+
+func TestSdNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := sdNotify("READY=1"); err != nil {
+		t.Errorf("expected no error without NOTIFY_SOCKET, got %v", err)
+	}
+
+	ch := fakeNotifySocket(t)
+	if err := sdNotify("READY=1\nSTATUS=ok"); err != nil {
+		t.Fatal(err)
+	}
+	if msg := receive(t, ch, "READY=1"); msg != "READY=1\nSTATUS=ok" {
+		t.Errorf("unexpected notification %q", msg)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "10000000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	if got := watchdogInterval(); got != 5*time.Second {
+		t.Errorf("expected half of WATCHDOG_USEC, got %v", got)
+	}
+	t.Setenv("WATCHDOG_PID", "1")
+	if got := watchdogInterval(); got != 0 {
+		t.Errorf("expected no watchdog for another process, got %v", got)
+	}
+	t.Setenv("WATCHDOG_USEC", "")
+	t.Setenv("WATCHDOG_PID", "")
+	if got := watchdogInterval(); got != 0 {
+		t.Errorf("expected no watchdog, got %v", got)
+	}
+}
+
+func TestListenFDsNotActivated(t *testing.T) {
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	listeners, err := listenFDs()
+	if err != nil || listeners != nil {
+		t.Errorf("expected no listener for another process, got %v %v", listeners, err)
+	}
+	if _, ok := os.LookupEnv("LISTEN_FDS"); ok {
+		t.Error("expected LISTEN_FDS to be unset")
+	}
+}
+
+// TestListenFDsActivated runs the test binary again with a listener at fd 3,
+// like systemd does, and checks that serve accepts clients on it.
+func TestListenFDsActivated(t *testing.T) {
+	if os.Getenv("NOSLEEP_TEST_ACTIVATED") == "1" {
+		// in the child: systemd sets LISTEN_PID to the pid of the service. The
+		// client only dials the activated socket, not the configured port.
+		os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid())) //nolint:errcheck
+		if err := serve(&Config{network: "tcp", address: "127.0.0.1", port: 1, drainTimeout: defaultDrainTimeout}); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	if runtime.GOOS == "windows" {
+		t.Skip("socket activation is not available on Windows")
+	}
+	ch := fakeNotifySocket(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	file, err := listener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var output strings.Builder
+	cmd := exec.Command(os.Args[0], "-test.run=^TestListenFDsActivated$")
+	cmd.Env = append(os.Environ(), "NOSLEEP_TEST_ACTIVATED=1", "LISTEN_FDS=1")
+	cmd.ExtraFiles = []*os.File{file} // fd 3
+	cmd.Stdout, cmd.Stderr = &output, &output
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill() //nolint:errcheck
+
+	receive(t, ch, "READY=1")
+	client, err := rpc.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var reply ExecStateReply
+	if err := client.Call("ExecStateManager.Read", ExecStateRequest{}, &reply); err != nil {
+		t.Fatalf("Read on the activated socket failed: %v", err)
+	}
+	if reply.Applied != ES_SYSTEM_REQUIRED {
+		t.Errorf("expected the initial system mode, got 0x%08X", reply.Applied)
+	}
+	if err := client.Call("ExecStateManager.Shutdown", ExecStateRequest{}, &ExecStateReply{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("activated server failed: %v\n%s", err, output.String())
+	}
+}
+
+func TestRunNotify(t *testing.T) {
+	ch := fakeNotifySocket(t)
+	manager := &ExecStateManager{}
+	manager.Start()
+	defer manager.Stop()
+	go manager.runNotify(10 * time.Millisecond)
+
+	receive(t, ch, "WATCHDOG=1")
+	if err := manager.Display(ExecStateRequest{}, &ExecStateReply{}); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, ch, "STATUS=display mode, 0 registered process(es)")
+}
@@ -0,0 +1,14 @@
+//go:build !windows && !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"runtime"
+)
+
+// makeRaw is not available on this platform: keys are read when Enter is pressed.
+func makeRaw(f *os.File) (func(), error) {
+	return nil, errors.New("raw terminal mode is not supported on " + runtime.GOOS)
+}
@@ -0,0 +1,62 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	ENABLE_LINE_INPUT                  = 0x0002
+	ENABLE_ECHO_INPUT                  = 0x0004
+	ENABLE_VIRTUAL_TERMINAL_INPUT      = 0x0200
+	ENABLE_VIRTUAL_TERMINAL_PROCESSING = 0x0004
+)
+
+var (
+	procGetConsoleMode = modkernel32.NewProc("GetConsoleMode")
+	procSetConsoleMode = modkernel32.NewProc("SetConsoleMode")
+)
+
+func getConsoleMode(h syscall.Handle) (uint32, error) {
+	var mode uint32
+	if r, _, err := procGetConsoleMode.Call(uintptr(h), uintptr(unsafe.Pointer(&mode))); r == 0 {
+		return 0, err
+	}
+	return mode, nil
+}
+
+func setConsoleMode(h syscall.Handle, mode uint32) error {
+	if r, _, err := procSetConsoleMode.Call(uintptr(h), uintptr(mode)); r == 0 {
+		return err
+	}
+	return nil
+}
+
+// makeRaw puts the console in a mode where keys are read as they are typed,
+// not echoed, and arrow keys arrive as ANSI sequences. It also enables the
+// ANSI sequences on the standard output. It returns a function restoring the
+// previous modes. CTRL+C is still processed by the system.
+func makeRaw(f *os.File) (func(), error) {
+	in := syscall.Handle(f.Fd())
+	inMode, err := getConsoleMode(in)
+	if err != nil {
+		return nil, err
+	}
+	if err := setConsoleMode(in, inMode&^(ENABLE_LINE_INPUT|ENABLE_ECHO_INPUT)|ENABLE_VIRTUAL_TERMINAL_INPUT); err != nil {
+		return nil, err
+	}
+	out := syscall.Handle(os.Stdout.Fd())
+	outMode, err := getConsoleMode(out)
+	if err == nil {
+		setConsoleMode(out, outMode|ENABLE_VIRTUAL_TERMINAL_PROCESSING) //nolint:errcheck
+	}
+	return func() {
+		setConsoleMode(in, inMode) //nolint:errcheck
+		if err == nil {
+			setConsoleMode(out, outMode) //nolint:errcheck
+		}
+	}, nil
+}
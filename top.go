@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"net/rpc"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// Default refresh interval of the top command, and number of events shown.
+const (
+	defaultTopInterval = time.Second
+	topEvents          = 10
+)
+
+// ANSI sequences of the top command.
+const (
+	ansiClear = "\x1b[H\x1b[2J"
+	ansiBold  = "\x1b[1m"
+	ansiReset = "\x1b[0m"
+)
+
+// Keys of the top command, and the method they call.
+var topKeys = map[string]string{
+	"s": "System",
+	"d": "Display",
+	"a": "Critical",
+	"c": "Clear",
+}
+
+// topState is what the top command shows.
+type topState struct {
+	server   string
+	read     *ExecStateReply
+	events   []Event // most recent last
+	selected int
+	message  string // outcome of the last action or error
+}
+
+// topEntry is a line that can be selected: a registration or a handle.
+type topEntry struct {
+	process int
+	handle  uint64
+}
+
+func (s *topState) entries() []topEntry {
+	if s.read == nil {
+		return nil
+	}
+	var entries []topEntry
+	for _, r := range s.read.Registrations {
+		entries = append(entries, topEntry{process: r.Process})
+	}
+	for _, h := range s.read.Handles {
+		entries = append(entries, topEntry{handle: h.ID})
+	}
+	return entries
+}
+
+// move moves the selection, staying on the entries.
+func (s *topState) move(delta int) {
+	s.selected = max(0, min(s.selected+delta, len(s.entries())-1))
+}
+
+// addEvents keeps the last topEvents events.
+func (s *topState) addEvents(events []Event) {
+	s.events = append(s.events, events...)
+	if n := len(s.events); n > topEvents {
+		s.events = s.events[n-topEvents:]
+	}
+}
+
+// marker returns the marker of the selected entry.
+func (s *topState) marker(i int) string {
+	if i == s.selected {
+		return ">"
+	}
+	return " "
+}
+
+// renderTop draws the state on the terminal.
+func renderTop(w io.Writer, s *topState, now time.Time) {
+	fmt.Fprint(w, ansiClear)
+	fmt.Fprintf(w, "%s%s top — %s — %s%s\n", ansiBold, name, s.server, now.Format(time.TimeOnly), ansiReset)
+	if r := s.read; r != nil {
+		status := "-"
+		if r.Health != nil {
+			status = fmt.Sprintf("%s, uptime %s", r.Health.Status, r.Health.Uptime.Round(time.Second))
+		}
+		fmt.Fprintf(w, "Mode: %s%s%s (0x%08X), health %s\n", ansiBold, modeName(r.Applied), ansiReset, r.Applied, status)
+
+		i := 0
+		fmt.Fprintf(w, "\n%sREGISTRATIONS%s\n", ansiBold, ansiReset)
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  PID\tCLIENT\tAGE")
+		for _, reg := range r.Registrations {
+			fmt.Fprintf(tw, "%s %d\t%s\t%s\n", s.marker(i), reg.Process, reg.Client, now.Sub(reg.Registered).Round(time.Second))
+			i++
+		}
+		tw.Flush() //nolint:errcheck
+
+		fmt.Fprintf(w, "\n%sHANDLES%s\n", ansiBold, ansiReset)
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  ID\tMODE\tCLIENT\tCONNECTION\tTTL")
+		for _, h := range r.Handles {
+			ttl := "-"
+			if !h.Expires.IsZero() {
+				ttl = max(h.Expires.Sub(now), 0).Round(time.Second).String()
+			}
+			fmt.Fprintf(tw, "%s %d\t%s\t%s\t%s\t%s\n", s.marker(i), h.ID, h.Mode, h.Client, h.Conn, ttl)
+			i++
+		}
+		tw.Flush() //nolint:errcheck
+
+		fmt.Fprintf(w, "\n%sCONDITIONS%s\n", ansiBold, ansiReset)
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "  NAME\tMODE\tHOLDING\tREASON")
+		for _, c := range r.Conditions {
+			fmt.Fprintf(tw, "  %s\t%s\t%t\t%s\n", c.Name, c.Mode, c.Holding, c.Reason)
+		}
+		tw.Flush() //nolint:errcheck
+	}
+
+	fmt.Fprintf(w, "\n%sEVENTS%s\n", ansiBold, ansiReset)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, e := range s.events {
+		detail := e.Detail
+		if e.Process != 0 {
+			detail = strings.TrimSpace(strconv.Itoa(e.Process) + " " + detail)
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", e.Time.Local().Format(time.TimeOnly), e.Type, detail)
+	}
+	tw.Flush() //nolint:errcheck
+
+	fmt.Fprintln(w, "\nKeys: s system, d display, a critical, c clear, j/k select, u unregister or release, q quit")
+	if s.message != "" {
+		fmt.Fprintln(w, s.message)
+	}
+}
+
+// readKeys sends the keys read from r: characters, and "up" and "down" for
+// the arrow keys. Line endings are ignored, for terminals without raw mode.
+func readKeys(r io.Reader, keys chan<- string) {
+	defer close(keys)
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return
+		}
+		switch b {
+		case '\r', '\n':
+			continue
+		case 0x1b:
+			// arrow keys are ESC [ A and ESC [ B
+			if next, err := br.ReadByte(); err != nil || next != '[' {
+				continue
+			}
+			switch c, _ := br.ReadByte(); c {
+			case 'A':
+				keys <- "up"
+			case 'B':
+				keys <- "down"
+			}
+		default:
+			keys <- string(b)
+		}
+	}
+}
+
+// topAction runs the action of a key, and returns its outcome.
+func topAction(client *rpc.Client, s *topState, key string) string {
+	req := ExecStateRequest{Client: "top"}
+	method, ok := topKeys[key]
+	if key == "u" {
+		entries := s.entries()
+		if len(entries) == 0 {
+			return "nothing selected"
+		}
+		e := entries[min(s.selected, len(entries)-1)]
+		if e.handle != 0 {
+			method, req.Handle = "Release", e.handle
+		} else {
+			method, req.Process = "Unregister", e.process
+		}
+	} else if !ok {
+		return ""
+	}
+	if err := client.Call("ExecStateManager."+method, req, &ExecStateReply{}); err != nil {
+		return method + " failed: " + err.Error()
+	}
+	return method + ": ok"
+}
+
+// watchEvents sends the events published by the server, until the connection
+// fails.
+func watchEvents(client *rpc.Client, cursor uint64, events chan<- []Event) {
+	defer close(events)
+	for {
+		var reply ExecStateReply
+		if err := client.Call("ExecStateManager.Watch", ExecStateRequest{Cursor: cursor, Wait: defaultWatchWait}, &reply); err != nil {
+			return
+		}
+		if len(reply.Events) > 0 {
+			events <- reply.Events
+		}
+		cursor = reply.Cursor
+	}
+}
+
+// topCommand shows the state of the server until q or CTRL+C is pressed.
+func topCommand(cfg *Config, args []string) int {
+	fs := flag.NewFlagSet("top", flag.ContinueOnError)
+	interval := fs.Duration("interval", defaultTopInterval, "refresh interval")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: "+name+" [-n network] [-a address] [-p port] top [OPTIONS]\n\nShows the state of the server live.\n\nOPTIONS:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 || *interval <= 0 {
+		fs.Usage()
+		return 2
+	}
+
+	client, err := dialServer(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer client.Close() //nolint:errcheck
+
+	s := &topState{server: serverAddress(cfg)}
+	refresh := func() {
+		var reply ExecStateReply
+		if err := client.Call("ExecStateManager.Read", ExecStateRequest{}, &reply); err != nil {
+			s.message = "Read failed: " + err.Error()
+			return
+		}
+		s.read = &reply
+		s.move(0)
+	}
+	refresh()
+	if s.read == nil {
+		fmt.Fprintln(os.Stderr, s.message)
+		return 1
+	}
+
+	if restore, err := makeRaw(os.Stdin); err == nil {
+		defer restore()
+	} else {
+		s.message = "Press Enter after the keys (" + err.Error() + ")"
+	}
+	keys := make(chan string)
+	go readKeys(os.Stdin, keys)
+	events := make(chan []Event)
+	go watchEvents(client, s.read.Cursor, events)
+
+	interruptCh := make(chan os.Signal, 1)
+	signal.Notify(interruptCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interruptCh)
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for {
+		renderTop(os.Stdout, s, time.Now())
+		select {
+		case <-ticker.C:
+			refresh()
+		case e, ok := <-events:
+			if !ok {
+				s.message = "Connection to the server lost"
+				events = nil
+				continue
+			}
+			s.addEvents(e)
+			refresh()
+		case key, ok := <-keys:
+			switch {
+			case !ok || key == "q":
+				return 0
+			case key == "up" || key == "k":
+				s.move(-1)
+			case key == "down" || key == "j":
+				s.move(1)
+			default:
+				if message := topAction(client, s, key); message != "" {
+					s.message = message
+					refresh()
+				}
+			}
+		case <-interruptCh:
+			return 0
+		}
+	}
+}
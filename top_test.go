@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadKeys(t *testing.T) {
+	keys := make(chan string)
+	go readKeys(strings.NewReader("s\x1b[A\x1b[Bq\n"), keys)
+	var got []string
+	for key := range keys {
+		got = append(got, key)
+	}
+	if strings.Join(got, ",") != "s,up,down,q" {
+		t.Errorf("expected s,up,down,q, got %v", got)
+	}
+}
+
+func TestRenderTop(t *testing.T) {
+	now := time.Date(2026, 3, 4, 21, 0, 0, 0, time.UTC)
+	s := &topState{
+		server: "127.0.0.1:9001",
+		read: &ExecStateReply{
+			Applied:       ES_SYSTEM_REQUIRED | ES_DISPLAY_REQUIRED,
+			Registrations: []Registration{{Process: 4242, Client: "backup", Registered: now.Add(-90 * time.Minute)}},
+			Handles: []Handle{
+				{ID: 1, Mode: "system", Client: "agent", Heartbeat: 10 * time.Second, Expires: now.Add(25 * time.Second)},
+				{ID: 2, Mode: "display", Client: "presenter", Conn: "127.0.0.1:50123"},
+			},
+			Conditions: []ConditionStatus{{Name: "activity", Mode: "system", Holding: true, Reason: "cpu 85% > 50%"}},
+		},
+	}
+	s.addEvents([]Event{{Time: now, Type: EventRegister, Process: 4242, Detail: "backup"}})
+	s.move(1)
+
+	var out bytes.Buffer
+	renderTop(&out, s, now)
+	for _, want := range []string{"display", "4242  backup", "1h30m0s", "> 1", "25s", "127.0.0.1:50123", "cpu 85% > 50%", "register  4242 backup"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %q in:\n%s", want, out.String())
+		}
+	}
+
+	s.move(10)
+	if s.selected != 2 {
+		t.Errorf("expected the selection to stop on the last entry, got %d", s.selected)
+	}
+	if e := s.entries()[s.selected]; e.handle != 2 {
+		t.Errorf("expected handle 2 to be selected, got %+v", e)
+	}
+}
+
+func TestTopAction(t *testing.T) {
+	manager, listener, client := setupTestServer(t)
+	defer listener.Close()
+	defer client.Close()
+	defer manager.Stop()
+	manager.keepOnEmpty = true
+
+	for _, pid := range []int{11, 22} {
+		if err := client.Call("ExecStateManager.Register", ExecStateRequest{Process: pid}, &ExecStateReply{}); err != nil {
+			t.Fatalf("Register RPC call failed: %v", err)
+		}
+	}
+	s := &topState{read: &ExecStateReply{}}
+	if err := client.Call("ExecStateManager.Read", ExecStateRequest{}, s.read); err != nil {
+		t.Fatalf("Read RPC call failed: %v", err)
+	}
+	s.move(1)
+	if message := topAction(client, s, "u"); message != "Unregister: ok" {
+		t.Errorf("unexpected outcome %q", message)
+	}
+	if pids := manager.getRegisteredProcesses(); len(pids) != 1 || pids[0] != 11 {
+		t.Errorf("expected process 22 to be unregistered, got %v", pids)
+	}
+	if message := topAction(client, s, "d"); message != "Display: ok" {
+		t.Errorf("unexpected outcome %q", message)
+	}
+	if flags := manager.appliedFlags(); flags != ES_SYSTEM_REQUIRED|ES_DISPLAY_REQUIRED {
+		t.Errorf("expected the display mode, got %#x", flags)
+	}
+	if message := topAction(client, s, "x"); message != "" {
+		t.Errorf("expected no action for an unknown key, got %q", message)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/subtle"
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Number of history intervals returned to the web page, and period of the
+// usage totals by default.
+const (
+	webIntervals   = 50
+	webReportSince = 24 * time.Hour
+)
+
+//go:embed web
+var webFiles embed.FS
+
+// webMethods are the methods the buttons of the web page call.
+var webMethods = map[string]func(*ExecStateManager, ExecStateRequest, *ExecStateReply) error{
+	"system":   (*ExecStateManager).System,
+	"display":  (*ExecStateManager).Display,
+	"critical": (*ExecStateManager).Critical,
+	"clear":    (*ExecStateManager).Clear,
+	"shutdown": (*ExecStateManager).Shutdown,
+}
+
+// registerWeb adds the admin page and its API to the HTTP endpoints:
+//
+//	/                    page showing the state, with buttons to change it
+//	/api/state           Read reply as JSON
+//	/api/history         usage totals and last mode intervals as JSON
+//	POST /api/{action}   system, display, critical, clear or shutdown
+//
+// When --listen sockets require a token, the API requires one of them too, as
+// "Authorization: Bearer TOKEN". The page itself holds no state and is open.
+func (m *ExecStateManager) registerWeb(mux *http.ServeMux) {
+	static, _ := fs.Sub(webFiles, "web")
+	mux.Handle("GET /", http.FileServerFS(static))
+	mux.HandleFunc("GET /api/state", m.requireToken(m.serveState))
+	mux.HandleFunc("GET /api/history", m.requireToken(m.serveHistory))
+	mux.HandleFunc("POST /api/{action}", m.requireToken(m.serveAction))
+}
+
+// requireToken denies the requests without a token of the --listen sockets,
+// if they have any.
+func (m *ExecStateManager) requireToken(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(m.webTokens) > 0 {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || !slices.ContainsFunc(m.webTokens, func(t string) bool {
+				return subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1
+			}) {
+				httpError(w, newError(ErrDenied, "invalid token"))
+				return
+			}
+		}
+		handler(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(v) //nolint:errcheck
+}
+
+func (m *ExecStateManager) serveState(w http.ResponseWriter, r *http.Request) {
+	var reply ExecStateReply
+	if err := m.Read(ExecStateRequest{}, &reply); err != nil {
+		httpError(w, err)
+		return
+	}
+	writeJSON(w, &reply)
+}
+
+// serveHistory returns the usage totals since the since query parameter (24h
+// ago by default), and the last mode intervals.
+func (m *ExecStateManager) serveHistory(w http.ResponseWriter, r *http.Request) {
+	now := m.now()
+	since := now.Add(-webReportSince)
+	if s := r.URL.Query().Get("since"); s != "" {
+		var err error
+		if since, err = parseTimeArg(s, now); err != nil {
+			httpError(w, withCode(ErrInvalid, err))
+			return
+		}
+	}
+	intervals := m.history.list(now)
+	writeJSON(w, struct {
+		Report    *UsageReport
+		Intervals []Interval
+	}{buildReport(intervals, since, now), intervals[max(len(intervals)-webIntervals, 0):]})
+}
+
+// serveAction calls the method of a button. The request must come from the
+// page itself: the custom header cannot be sent cross-origin without a CORS
+// preflight, which is not answered, so other sites cannot post to it.
+func (m *ExecStateManager) serveAction(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Nosleep-Request") == "" || !sameOrigin(r) {
+		httpError(w, newError(ErrDenied, "cross-origin request"))
+		return
+	}
+	method, ok := webMethods[r.PathValue("action")]
+	if !ok {
+		httpError(w, newError(ErrNotFound, "unknown action %q", r.PathValue("action")))
+		return
+	}
+	req := ExecStateRequest{Client: "web", remote: r.RemoteAddr}
+	var reply ExecStateReply
+	if err := method(m, req, &reply); err != nil {
+		httpError(w, err)
+		return
+	}
+	writeJSON(w, &reply)
+}
+
+// isLoopbackAddr reports whether a host:port address only listens on the
+// loopback interface. An empty host listens on all interfaces.
+func isLoopbackAddr(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip, err := netip.ParseAddr(host)
+	return err == nil && ip.IsLoopback()
+}
+
+// sameOrigin reports whether the Origin header, if any, is the server itself.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWeb(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := &ExecStateManager{listener: listener, web: true}
+	manager.Start()
+	defer manager.Stop()
+
+	server := httptest.NewServer(manager.httpHandler())
+	defer server.Close()
+
+	post := func(action string, header http.Header) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/api/"+action, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	page := http.Header{"X-Nosleep-Request": {"1"}}
+
+	resp, err := http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "EventSource") {
+		t.Errorf("expected the admin page, got %d", resp.StatusCode)
+	}
+
+	if resp := post("display", page); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected display to succeed, got %d", resp.StatusCode)
+	}
+	if flags := manager.appliedFlags(); flags != ES_SYSTEM_REQUIRED|ES_DISPLAY_REQUIRED {
+		t.Errorf("expected display flags, got 0x%08X", flags)
+	}
+
+	// actions from other pages are refused
+	if resp := post("clear", nil); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403 without header, got %d", resp.StatusCode)
+	}
+	if resp := post("clear", http.Header{"X-Nosleep-Request": {"1"}, "Origin": {"http://evil.example"}}); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403 from another origin, got %d", resp.StatusCode)
+	}
+	if flags := manager.appliedFlags(); flags != ES_SYSTEM_REQUIRED|ES_DISPLAY_REQUIRED {
+		t.Errorf("expected display flags kept, got 0x%08X", flags)
+	}
+	if resp := post("unknown", page); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown action, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(server.URL + "/api/state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state ExecStateReply
+	err = json.NewDecoder(resp.Body).Decode(&state)
+	resp.Body.Close()
+	if err != nil || state.Applied != ES_SYSTEM_REQUIRED|ES_DISPLAY_REQUIRED || state.Health == nil {
+		t.Errorf("expected the state, got %+v (%v)", state, err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	resp, err = http.Get(server.URL + "/api/history?since=1h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var history struct {
+		Report    *UsageReport
+		Intervals []Interval
+	}
+	err = json.NewDecoder(resp.Body).Decode(&history)
+	resp.Body.Close()
+	if err != nil || history.Report == nil || len(history.Intervals) == 0 {
+		t.Errorf("expected the history, got %+v (%v)", history, err)
+	}
+	if resp, _ := http.Get(server.URL + "/api/history?since=bogus"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid time, got %d", resp.StatusCode)
+	}
+
+	if resp := post("shutdown", page); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected shutdown to succeed, got %d", resp.StatusCode)
+	}
+	if _, err := listener.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected the listener closed, got %v", err)
+	}
+}
+
+func TestWebToken(t *testing.T) {
+	manager := &ExecStateManager{web: true, webTokens: []string{"s3cret", "other"}}
+	manager.Start()
+	defer manager.Stop()
+
+	server := httptest.NewServer(manager.httpHandler())
+	defer server.Close()
+
+	tests := []struct {
+		method, path, auth string
+		status             int
+	}{
+		{http.MethodGet, "/", "", http.StatusOK},
+		{http.MethodGet, "/api/state", "", http.StatusForbidden},
+		{http.MethodGet, "/api/history", "Bearer wrong", http.StatusForbidden},
+		{http.MethodGet, "/api/state", "Bearer other", http.StatusOK},
+		{http.MethodGet, "/api/history", "Bearer s3cret", http.StatusOK},
+		{http.MethodPost, "/api/system", "", http.StatusForbidden},
+		{http.MethodPost, "/api/system", "s3cret", http.StatusForbidden},
+		{http.MethodPost, "/api/system", "Bearer s3cret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, server.URL+tt.path, nil)
+		req.Header.Set("X-Nosleep-Request", "1")
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s %s with %q: expected %d, got %d", tt.method, tt.path, tt.auth, tt.status, resp.StatusCode)
+		}
+	}
+	if flags := manager.appliedFlags(); flags != ES_SYSTEM_REQUIRED {
+		t.Errorf("expected system flags, got 0x%08X", flags)
+	}
+}
+
+func TestServeWebOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nosleep.sock")
+	cfg := &Config{network: "unix", address: path, httpAddr: "0.0.0.0:0", web: true}
+
+	// no --listen socket has a token to protect the page off loopback
+	err := serve(cfg)
+	if err == nil || !strings.Contains(err.Error(), "requires a --listen socket with a token") {
+		t.Fatalf("expected a token error, got %v", err)
+	}
+}
+
+func TestIsLoopbackAddr(t *testing.T) {
+	tests := map[string]bool{
+		"127.0.0.1:9002": true,
+		"127.0.0.2:9002": true,
+		"[::1]:9002":     true,
+		"localhost:9002": true,
+		":9002":          false,
+		"0.0.0.0:9002":   false,
+		"[::]:9002":      false,
+		"10.0.4.2:9002":  false,
+		"lab1:9002":      false,
+		"127.0.0.1":      false,
+	}
+	for address, want := range tests {
+		if got := isLoopbackAddr(address); got != want {
+			t.Errorf("isLoopbackAddr(%q) = %v, expected %v", address, got, want)
+		}
+	}
+}
+
+func TestWebDisabled(t *testing.T) {
+	manager := &ExecStateManager{}
+	server := httptest.NewServer(manager.httpHandler())
+	defer server.Close()
+
+	for _, path := range []string{"/", "/api/state"} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: expected 404 without --web, got %d", path, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+//go:build windows
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// Offset of the rows in MIB_IF_TABLE2, after the entry count, aligned on 8
+	// bytes on all architectures.
+	mibIfTable2Rows = 8
+
+	// The network interface is a software loopback interface.
+	IF_TYPE_SOFTWARE_LOOPBACK = 24
+
+	// The TCP connection is in the ESTABLISHED state.
+	MIB_TCP_STATE_ESTAB = 5
+
+	ERROR_INSUFFICIENT_BUFFER = 122
+
+	IF_MAX_STRING_SIZE         = 256
+	IF_MAX_PHYS_ADDRESS_LENGTH = 32
+
+	// Bit of MIB_IF_ROW2.InterfaceAndOperStatusFlags set for the filter
+	// interfaces stacked on a network adapter, which count its traffic again.
+	IF_FLAG_FILTER_INTERFACE = 0x02
+)
+
+var (
+	modiphlpapi      = syscall.NewLazyDLL("iphlpapi.dll")
+	procGetIfTable2  = modiphlpapi.NewProc("GetIfTable2")
+	procFreeMibTable = modiphlpapi.NewProc("FreeMibTable")
+	procGetTcpTable  = modiphlpapi.NewProc("GetTcpTable")
+	procGetTcp6Table = modiphlpapi.NewProc("GetTcp6Table")
+)
+
+// MIB_IF_ROW2 stores information about a particular interface, with 64-bit
+// traffic counters. Windows aligns its 64-bit fields on 8 bytes also on 386,
+// where Go aligns them on 4, hence the explicit padding.
+//
+// See: https://learn.microsoft.com/en-us/windows/win32/api/netioapi/ns-netioapi-mib_if_row2
+type MIB_IF_ROW2 struct {
+	InterfaceLuid               uint64
+	InterfaceIndex              uint32
+	InterfaceGuid               [16]byte
+	Alias                       [IF_MAX_STRING_SIZE + 1]uint16
+	Description                 [IF_MAX_STRING_SIZE + 1]uint16
+	PhysicalAddressLength       uint32
+	PhysicalAddress             [IF_MAX_PHYS_ADDRESS_LENGTH]byte
+	PermanentPhysicalAddress    [IF_MAX_PHYS_ADDRESS_LENGTH]byte
+	Mtu                         uint32
+	Type                        uint32
+	TunnelType                  uint32
+	MediaType                   uint32
+	PhysicalMediumType          uint32
+	AccessType                  uint32
+	DirectionType               uint32
+	InterfaceAndOperStatusFlags uint8
+	OperStatus                  uint32
+	AdminStatus                 uint32
+	MediaConnectState           uint32
+	NetworkGuid                 [16]byte
+	ConnectionType              uint32
+	_                           [4]byte
+	TransmitLinkSpeed           uint64
+	ReceiveLinkSpeed            uint64
+	InOctets                    uint64
+	InUcastPkts                 uint64
+	InNUcastPkts                uint64
+	InDiscards                  uint64
+	InErrors                    uint64
+	InUnknownProtos             uint64
+	InUcastOctets               uint64
+	InMulticastOctets           uint64
+	InBroadcastOctets           uint64
+	OutOctets                   uint64
+	OutUcastPkts                uint64
+	OutNUcastPkts               uint64
+	OutDiscards                 uint64
+	OutErrors                   uint64
+	OutUcastOctets              uint64
+	OutMulticastOctets          uint64
+	OutBroadcastOctets          uint64
+	OutQLen                     uint64
+}
+
+// GetIfTable2 retrieves the interface table with 64-bit counters. Unlike the
+// other tables, it is allocated by the system and freed with FreeMibTable.
+//
+// See: https://learn.microsoft.com/en-us/windows/win32/api/netioapi/nf-netioapi-getiftable2
+func GetIfTable2() ([]MIB_IF_ROW2, error) {
+	var table unsafe.Pointer
+	if ret, _, _ := procGetIfTable2.Call(uintptr(unsafe.Pointer(&table))); ret != 0 {
+		return nil, syscall.Errno(ret)
+	}
+	defer procFreeMibTable.Call(uintptr(table)) //nolint:errcheck
+
+	n := *(*uint32)(table)
+	if n == 0 {
+		return nil, nil
+	}
+	rows := unsafe.Slice((*MIB_IF_ROW2)(unsafe.Add(table, mibIfTable2Rows)), n)
+	return append([]MIB_IF_ROW2(nil), rows...), nil
+}
+
+// MIB_TCPROW contains information that describes an IPv4 TCP connection.
+// Addresses and ports are in network byte order.
+//
+// See: https://learn.microsoft.com/en-us/windows/win32/api/tcpmib/ns-tcpmib-mib_tcprow_lh
+type MIB_TCPROW struct {
+	State      uint32
+	LocalAddr  uint32
+	LocalPort  uint32
+	RemoteAddr uint32
+	RemotePort uint32
+}
+
+// GetTcpTable retrieves the IPv4 TCP connection table.
+//
+// See: https://learn.microsoft.com/en-us/windows/win32/api/iphlpapi/nf-iphlpapi-gettcptable
+func GetTcpTable() ([]MIB_TCPROW, error) {
+	return getTable[MIB_TCPROW](procGetTcpTable)
+}
+
+// MIB_TCP6ROW contains information that describes an IPv6 TCP connection.
+// Ports are in network byte order.
+//
+// See: https://learn.microsoft.com/en-us/windows/win32/api/tcpmib/ns-tcpmib-mib_tcp6row
+type MIB_TCP6ROW struct {
+	State         uint32
+	LocalAddr     [16]byte
+	LocalScopeId  uint32
+	LocalPort     uint32
+	RemoteAddr    [16]byte
+	RemoteScopeId uint32
+	RemotePort    uint32
+}
+
+// GetTcp6Table retrieves the IPv6 TCP connection table.
+//
+// See: https://learn.microsoft.com/en-us/windows/win32/api/iphlpapi/nf-iphlpapi-gettcp6table
+func GetTcp6Table() ([]MIB_TCP6ROW, error) {
+	return getTable[MIB_TCP6ROW](procGetTcp6Table)
+}
+
+// getTable calls one of the Get*Table functions, which all take a buffer, its
+// size and a sort order, and return a DWORD entry count followed by the rows.
+func getTable[T any](proc *syscall.LazyProc) ([]T, error) {
+	var size uint32
+	ret, _, _ := proc.Call(0, uintptr(unsafe.Pointer(&size)), 0)
+	for ret == ERROR_INSUFFICIENT_BUFFER {
+		buf := make([]byte, size)
+		ret, _, _ = proc.Call(uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&size)), 0)
+		if ret == 0 {
+			n := *(*uint32)(unsafe.Pointer(&buf[0]))
+			if n == 0 {
+				return nil, nil
+			}
+			// rows are aligned on 4 bytes, right after the entry count
+			rows := unsafe.Slice((*T)(unsafe.Pointer(&buf[4])), n)
+			return append([]T(nil), rows...), nil
+		}
+	}
+	if ret != 0 {
+		return nil, syscall.Errno(ret)
+	}
+	return nil, nil
+}
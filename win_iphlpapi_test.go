@@ -0,0 +1,31 @@
+//go:build windows
+
+package main
+
+import (
+	"testing"
+	"unsafe"
+)
+
+// TestMibIfRow2Layout checks the struct against the offsets of the Windows
+// headers, the same on 386 and amd64.
+func TestMibIfRow2Layout(t *testing.T) {
+	var row MIB_IF_ROW2
+	tests := []struct {
+		name      string
+		got, want uintptr
+	}{
+		{"size", unsafe.Sizeof(row), 1352},
+		{"InterfaceAndOperStatusFlags", unsafe.Offsetof(row.InterfaceAndOperStatusFlags), 1152},
+		{"ConnectionType", unsafe.Offsetof(row.ConnectionType), 1184},
+		{"TransmitLinkSpeed", unsafe.Offsetof(row.TransmitLinkSpeed), 1192},
+		{"InOctets", unsafe.Offsetof(row.InOctets), 1208},
+		{"OutOctets", unsafe.Offsetof(row.OutOctets), 1280},
+		{"OutQLen", unsafe.Offsetof(row.OutQLen), 1344},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, tt.got)
+		}
+	}
+}
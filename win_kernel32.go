@@ -4,6 +4,7 @@ package main
 
 import (
 	"syscall"
+	"unsafe"
 )
 
 const (
@@ -27,11 +28,17 @@ const (
 	// This value is not supported. If ES_USER_PRESENT is combined with other esFlags
 	// values, the call will fail and none of the specified states will be set.
 	ES_USER_PRESENT = 0x00000004
+
+	// Required to retrieve certain information about a process, like its image name.
+	PROCESS_QUERY_LIMITED_INFORMATION = 0x1000
 )
 
 var (
 	modkernel32                 = syscall.NewLazyDLL("kernel32.dll")
 	procSetThreadExecutionState = modkernel32.NewProc("SetThreadExecutionState")
+	procGetSystemTimes          = modkernel32.NewProc("GetSystemTimes")
+	procQueryFullProcessImage   = modkernel32.NewProc("QueryFullProcessImageNameW")
+	procGetSystemPowerStatus    = modkernel32.NewProc("GetSystemPowerStatus")
 )
 
 // SetThreadExecutionState sets the thread's execution state using the Windows API.
@@ -48,3 +55,63 @@ func SetThreadExecutionState(flags uint32) (uint32, error) {
 	}
 	return uint32(ret), nil
 }
+
+// GetSystemTimes retrieves system timing information, in 100-nanosecond intervals.
+// On a multiprocessor system, the values are the sum across all processors.
+// The kernel time includes the idle time.
+//
+// See: https://learn.microsoft.com/en-us/windows/win32/api/processthreadsapi/nf-processthreadsapi-getsystemtimes
+func GetSystemTimes() (idle, kernel, user uint64, err error) {
+	var idleTime, kernelTime, userTime syscall.Filetime
+	ret, _, err := procGetSystemTimes.Call(
+		uintptr(unsafe.Pointer(&idleTime)),
+		uintptr(unsafe.Pointer(&kernelTime)),
+		uintptr(unsafe.Pointer(&userTime)))
+	if ret == 0 {
+		return 0, 0, 0, err
+	}
+	return filetimeTicks(idleTime), filetimeTicks(kernelTime), filetimeTicks(userTime), nil
+}
+
+func filetimeTicks(ft syscall.Filetime) uint64 {
+	return uint64(ft.HighDateTime)<<32 | uint64(ft.LowDateTime)
+}
+
+// QueryFullProcessImageName retrieves the full name of the executable image
+// for the specified process.
+//
+// See: https://learn.microsoft.com/en-us/windows/win32/api/winbase/nf-winbase-queryfullprocessimagenamew
+func QueryFullProcessImageName(process syscall.Handle) (string, error) {
+	buf := make([]uint16, syscall.MAX_LONG_PATH)
+	size := uint32(len(buf))
+	ret, _, err := procQueryFullProcessImage.Call(uintptr(process), 0,
+		uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&size)))
+	if ret == 0 {
+		return "", err
+	}
+	return syscall.UTF16ToString(buf[:size]), nil
+}
+
+// SYSTEM_POWER_STATUS contains information about the power status of the system.
+//
+// See: https://learn.microsoft.com/en-us/windows/win32/api/winbase/ns-winbase-system_power_status
+type SYSTEM_POWER_STATUS struct {
+	ACLineStatus        byte // 0 = offline, 1 = online, 255 = unknown
+	BatteryFlag         byte // 128 = no system battery, 255 = unknown
+	BatteryLifePercent  byte // 255 = unknown
+	SystemStatusFlag    byte
+	BatteryLifeTime     uint32
+	BatteryFullLifeTime uint32
+}
+
+// GetSystemPowerStatus retrieves the power status of the system.
+//
+// See: https://learn.microsoft.com/en-us/windows/win32/api/winbase/nf-winbase-getsystempowerstatus
+func GetSystemPowerStatus() (SYSTEM_POWER_STATUS, error) {
+	var status SYSTEM_POWER_STATUS
+	ret, _, err := procGetSystemPowerStatus.Call(uintptr(unsafe.Pointer(&status)))
+	if ret == 0 {
+		return status, err
+	}
+	return status, nil
+}